@@ -0,0 +1,567 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/apikey"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/export"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/ingest"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/live"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/schedule"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DeletionsTable hash key is userID
+	// Its items are the tombstones of deleted accounts
+	DeletionsTable = "account_deletions"
+	// PendingIndex is a sparse index of DeletionsTable holding unfinished deletions
+	// Hash key is Pending, range key is RequestedAt
+	PendingIndex = "PendingIndex"
+)
+
+// TimestampFormat sorts lexicographically in chronological order
+const TimestampFormat = "2006-01-02T15:04:05.000Z"
+
+const pending = "pending"
+
+// resumeDelay is how long a deletion is left to the request that
+// started it before it is resumed
+const resumeDelay = 5 * time.Minute
+
+// batchSize is the most items a BatchWriteItem request can hold
+const batchSize = 25
+
+// Steps of a deletion in the order they run
+// The cognito user is deleted last so the user can still log in and
+// retry for as long as anything else is left
+// The API keys go first so scripts stop changing what is being deleted
+const (
+	StepAPIKeys       = "apikeys"
+	StepDevices       = "devices"
+	StepWebhooks      = "webhooks"
+	StepNotifications = "notifications"
+	StepAlerts        = "alerts"
+	StepSchedules     = "schedules"
+	StepConnections   = "connections"
+	StepMemberships   = "memberships"
+	StepExports       = "exports"
+	StepProfile       = "profile"
+	StepCognito       = "cognito"
+)
+
+var steps = []string{
+	StepAPIKeys,
+	StepDevices,
+	StepWebhooks,
+	StepNotifications,
+	StepAlerts,
+	StepSchedules,
+	StepConnections,
+	StepMemberships,
+	StepExports,
+	StepProfile,
+	StepCognito,
+}
+
+// ErrCompleted is returned when the account was already deleted
+var ErrCompleted = errors.New("account already deleted")
+
+// ErrOwnerChanged is returned when a device to delete is no longer
+// owned by the expected owner
+var ErrOwnerChanged = errors.New("device owner changed")
+
+// Deletion is the tombstone of an account
+// Every step is idempotent, a deletion that failed halfway is resumed
+// by running it again and skips the steps it already completed
+type Deletion struct {
+	UserID string
+	// Email is the cognito username of the user, it is removed
+	// from the tombstone once the deletion completed
+	Email          string
+	RequestedAt    string
+	CompletedAt    string
+	CompletedSteps []string
+	Attempts       int
+	LastError      string
+}
+
+// Completed reports whether the step already ran
+func (d Deletion) Completed(step string) bool {
+	for _, completed := range d.CompletedSteps {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Start records the tombstone of the account, or returns the existing
+// one of a deletion that has not completed yet
+func Start(dynamoService *dynamodb.DynamoDB, userID string, email string, now time.Time) (Deletion, error) {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(DeletionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression:    aws.String("SET Email = if_not_exists(Email, :e), RequestedAt = if_not_exists(RequestedAt, :r), Pending = :p"),
+		ConditionExpression: aws.String("attribute_not_exists(CompletedAt)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e": {S: aws.String(email)},
+			":r": {S: aws.String(now.UTC().Format(TimestampFormat))},
+			":p": {S: aws.String(pending)},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+	}
+
+	dynamoResponse, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return Deletion{}, ErrCompleted
+		}
+		return Deletion{}, fmt.Errorf("error recording deletion of %s: %v", userID, err)
+	}
+
+	return deletionFromItem(dynamoResponse.Attributes), nil
+}
+
+// PendingDeletions returns the unfinished deletions requested before
+// The sparse index only holds unfinished deletions, so this does not
+// scan the tombstones
+func PendingDeletions(dynamoService *dynamodb.DynamoDB, before time.Time) ([]Deletion, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(DeletionsTable),
+		IndexName:              aws.String(PendingIndex),
+		KeyConditionExpression: aws.String("Pending = :p AND RequestedAt <= :b"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(pending)},
+			":b": {S: aws.String(before.UTC().Format(TimestampFormat))},
+		},
+	}
+
+	var deletions []Deletion
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			deletions = append(deletions, deletionFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pending deletions: %v", err)
+	}
+
+	return deletions, nil
+}
+
+// Run deletes everything of the account that is left and marks the
+// deletion as completed
+// A failed step is recorded on the tombstone and stops the deletion
+func Run(dynamoService *dynamodb.DynamoDB, cognitoService *cognitoidentityprovider.CognitoIdentityProvider, s3Service *s3.S3, userPoolID string, exportBucket string, deletion Deletion, now time.Time) error {
+	for _, step := range steps {
+		if deletion.Completed(step) {
+			continue
+		}
+
+		err := runStep(dynamoService, cognitoService, s3Service, userPoolID, exportBucket, deletion, step)
+		if err != nil {
+			recordErr := recordFailure(dynamoService, deletion.UserID, err)
+			if recordErr != nil {
+				return fmt.Errorf("%v, %v", err, recordErr)
+			}
+			return err
+		}
+
+		err = completeStep(dynamoService, deletion.UserID, step)
+		if err != nil {
+			return err
+		}
+	}
+
+	return complete(dynamoService, deletion.UserID, now)
+}
+
+// Resume runs the deletions that are still pending a while after they
+// were requested, which the request deleting the account had time to finish
+func Resume(dynamoService *dynamodb.DynamoDB, cognitoService *cognitoidentityprovider.CognitoIdentityProvider, s3Service *s3.S3, userPoolID string, exportBucket string, now time.Time) error {
+	deletions, err := PendingDeletions(dynamoService, now.Add(-resumeDelay))
+	if err != nil {
+		return err
+	}
+
+	for _, deletion := range deletions {
+		err = Run(dynamoService, cognitoService, s3Service, userPoolID, exportBucket, deletion, now)
+		if err != nil {
+			log.Printf("Error resuming deletion of %s: %v\n", deletion.UserID, err)
+		}
+	}
+
+	return nil
+}
+
+func runStep(dynamoService *dynamodb.DynamoDB, cognitoService *cognitoidentityprovider.CognitoIdentityProvider, s3Service *s3.S3, userPoolID string, exportBucket string, deletion Deletion, step string) error {
+	owner := deletion.UserID
+
+	switch step {
+	case StepAPIKeys:
+		return deleteAll(dynamoService, apikey.TableName, apikey.UserIndex, "userID", owner, "KeyID")
+	case StepDevices:
+		return deleteDevices(dynamoService, owner)
+	case StepWebhooks:
+		webhooks, err := queryKeys(dynamoService, webhook.SubscriptionsTable, "", "Owner", owner, "Owner", "WebhookID")
+		if err != nil {
+			return err
+		}
+		for _, key := range webhooks {
+			webhookID := stringValue(key["WebhookID"])
+			err = deleteAll(dynamoService, webhook.DeliveriesTable, "", "WebhookID", webhookID, "WebhookID", "DeliveryID")
+			if err != nil {
+				return err
+			}
+			err = deleteAll(dynamoService, webhook.DeadLettersTable, "", "WebhookID", webhookID, "WebhookID", "DeliveryID")
+			if err != nil {
+				return err
+			}
+		}
+		return deleteKeys(dynamoService, webhook.SubscriptionsTable, webhooks)
+	case StepNotifications:
+		err := deleteAll(dynamoService, notify.ChannelsTable, "", "Owner", owner, "Owner", "ChannelID")
+		if err != nil {
+			return err
+		}
+		return deleteAll(dynamoService, notify.SubscriptionsTable, "", "Owner", owner, "Owner", "MAC")
+	case StepAlerts:
+		rules, err := queryKeys(dynamoService, alert.RulesTable, "", "Owner", owner, "Owner", "RuleID")
+		if err != nil {
+			return err
+		}
+		for _, key := range rules {
+			err = deleteAll(dynamoService, alert.StatesTable, "", "RuleID", stringValue(key["RuleID"]), "RuleID", "MAC")
+			if err != nil {
+				return err
+			}
+		}
+		err = deleteKeys(dynamoService, alert.RulesTable, rules)
+		if err != nil {
+			return err
+		}
+		return deleteAll(dynamoService, alert.HistoryTable, "", "Owner", owner, "Owner", "HistoryID")
+	case StepSchedules:
+		schedules, err := queryKeys(dynamoService, schedule.SchedulesTable, "", "Owner", owner, "Owner", "ScheduleID")
+		if err != nil {
+			return err
+		}
+		for _, key := range schedules {
+			err = deleteAll(dynamoService, schedule.RunsTable, "", "ScheduleID", stringValue(key["ScheduleID"]), "ScheduleID", "RunAt")
+			if err != nil {
+				return err
+			}
+		}
+		return deleteKeys(dynamoService, schedule.SchedulesTable, schedules)
+	case StepConnections:
+		return deleteAll(dynamoService, live.ConnectionsTable, live.OwnerIndex, "Owner", owner, "ConnectionID")
+	case StepMemberships:
+		return leaveOrganizations(dynamoService, owner)
+	case StepExports:
+		return export.DeleteExports(dynamoService, s3Service, exportBucket, deletion.UserID)
+	case StepProfile:
+		return deleteKeys(dynamoService, auth.UsersTable, []map[string]*dynamodb.AttributeValue{
+			{"userID": {S: aws.String(deletion.UserID)}},
+		})
+	case StepCognito:
+		cognitoInput := cognitoidentityprovider.AdminDeleteUserInput{
+			Username:   aws.String(deletion.Email),
+			UserPoolId: aws.String(userPoolID),
+		}
+		_, err := cognitoService.AdminDeleteUser(&cognitoInput)
+		if err != nil && auth.Code(err) != cognitoidentityprovider.ErrCodeUserNotFoundException {
+			return fmt.Errorf("error deleting cognito user %s: %v", deletion.UserID, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown deletion step %s", step)
+}
+
+// deleteDevices deletes the devices of the owner along with their
+// audit log and telemetry
+func deleteDevices(dynamoService *dynamodb.DynamoDB, owner string) error {
+	devices, err := queryKeys(dynamoService, device.TableName, device.OwnerIndex, "Owner", owner, "MAC")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range devices {
+		// The index is eventually consistent, a device that changed owner
+		// in the meantime is left alone
+		err = DeleteDevice(dynamoService, stringValue(key["MAC"]), owner)
+		if err != nil && err != ErrOwnerChanged {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leaveOrganizations removes the memberships of the user
+// Devices of the organizations stay with them, unless the user was the
+// only member and nobody would be left to manage them
+func leaveOrganizations(dynamoService *dynamodb.DynamoDB, userID string) error {
+	organizations, err := org.ListOrganizations(dynamoService, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, organization := range organizations {
+		members, err := org.ListMembers(dynamoService, organization.OrgID)
+		if err != nil {
+			return err
+		}
+		if len(members) == 1 {
+			err = deleteDevices(dynamoService, org.Owner(organization.OrgID))
+			if err != nil {
+				return err
+			}
+			err = org.Delete(dynamoService, organization.OrgID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// account_delete refuses last admins, this covers members made
+		// last admin while the deletion was pending
+		err = org.Leave(dynamoService, organization.OrgID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDevice deletes the device of the owner along with its audit log
+// and telemetry, which frees its MAC to be registered again
+// A device that is not owned by the owner returns ErrOwnerChanged
+func DeleteDevice(dynamoService *dynamodb.DynamoDB, mac string, owner string) error {
+	err := deleteAll(dynamoService, audit.TableName, "", "MAC", mac, "MAC", "Timestamp")
+	if err != nil {
+		return err
+	}
+	err = deleteAll(dynamoService, ingest.TelemetryTable, "", "MAC", mac, "MAC", "Timestamp")
+	if err != nil {
+		return err
+	}
+
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(device.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		ConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+	_, err = dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrOwnerChanged
+		}
+		return fmt.Errorf("error deleting device %s: %v", mac, err)
+	}
+
+	return nil
+}
+
+// deleteAll deletes every item of the table, or of the table the index
+// belongs to, whose hash key is hashValue
+func deleteAll(dynamoService *dynamodb.DynamoDB, table string, index string, hashKey string, hashValue string, keyNames ...string) error {
+	keys, err := queryKeys(dynamoService, table, index, hashKey, hashValue, keyNames...)
+	if err != nil {
+		return err
+	}
+	return deleteKeys(dynamoService, table, keys)
+}
+
+// queryKeys returns the primary keys of every item whose hash key is hashValue
+func queryKeys(dynamoService *dynamodb.DynamoDB, table string, index string, hashKey string, hashValue string, keyNames ...string) ([]map[string]*dynamodb.AttributeValue, error) {
+	expressionAttributeNames := map[string]*string{
+		"#h": aws.String(hashKey),
+	}
+	var projection []string
+	for i, keyName := range keyNames {
+		placeholder := "#k" + strconv.Itoa(i)
+		projection = append(projection, placeholder)
+		expressionAttributeNames[placeholder] = aws.String(keyName)
+	}
+
+	dynamoInput := dynamodb.QueryInput{
+		TableName:                aws.String(table),
+		KeyConditionExpression:   aws.String("#h = :h"),
+		ProjectionExpression:     aws.String(strings.Join(projection, ", ")),
+		ExpressionAttributeNames: expressionAttributeNames,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":h": {S: aws.String(hashValue)},
+		},
+	}
+	if index != "" {
+		dynamoInput.IndexName = aws.String(index)
+	}
+
+	var keys []map[string]*dynamodb.AttributeValue
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		keys = append(keys, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s of %s: %v", table, hashValue, err)
+	}
+
+	return keys, nil
+}
+
+// deleteKeys deletes the items in batches, retrying the items dynamo
+// did not process
+func deleteKeys(dynamoService *dynamodb.DynamoDB, table string, keys []map[string]*dynamodb.AttributeValue) error {
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var requests []*dynamodb.WriteRequest
+		for _, key := range keys[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+			})
+		}
+
+		unprocessed := map[string][]*dynamodb.WriteRequest{table: requests}
+		for attempt := 0; len(unprocessed) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("error deleting from %s: items left unprocessed", table)
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt*100) * time.Millisecond)
+			}
+
+			dynamoResponse, err := dynamoService.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: unprocessed,
+			})
+			if err != nil {
+				return fmt.Errorf("error deleting from %s: %v", table, err)
+			}
+			unprocessed = dynamoResponse.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+func completeStep(dynamoService *dynamodb.DynamoDB, userID string, step string) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(DeletionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("ADD CompletedSteps :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {SS: []*string{aws.String(step)}},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error recording deletion step %s of %s: %v", step, userID, err)
+	}
+	return nil
+}
+
+func recordFailure(dynamoService *dynamodb.DynamoDB, userID string, stepErr error) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(DeletionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("SET LastError = :e ADD Attempts :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e":   {S: aws.String(stepErr.Error())},
+			":one": {N: aws.String("1")},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error recording failed deletion of %s: %v", userID, err)
+	}
+	return nil
+}
+
+// complete drops the tombstone out of the pending index and forgets
+// the email of the user
+func complete(dynamoService *dynamodb.DynamoDB, userID string, now time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(DeletionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("SET CompletedAt = :c REMOVE Pending, Email, LastError"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(now.UTC().Format(TimestampFormat))},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error completing deletion of %s: %v", userID, err)
+	}
+	return nil
+}
+
+func deletionFromItem(item map[string]*dynamodb.AttributeValue) Deletion {
+	deletion := Deletion{
+		UserID:      stringValue(item["userID"]),
+		Email:       stringValue(item["Email"]),
+		RequestedAt: stringValue(item["RequestedAt"]),
+		CompletedAt: stringValue(item["CompletedAt"]),
+		LastError:   stringValue(item["LastError"]),
+	}
+	if item["CompletedSteps"] != nil {
+		deletion.CompletedSteps = aws.StringValueSlice(item["CompletedSteps"].SS)
+	}
+	if item["Attempts"] != nil && item["Attempts"].N != nil {
+		deletion.Attempts, _ = strconv.Atoi(*item["Attempts"].N)
+	}
+	return deletion
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/account"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"os"
+	"time"
+)
+
+// DeleteAccountEvent defines the request structure of this account deletion request
+type DeleteAccountEvent struct {
+	Password string `json:"password"`
+}
+
+// Response defines the response structure to this account deletion request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// DeleteAccount is the lambda function handler
+// it deletes the logged in user along with their devices and everything else they own
+func DeleteAccount(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt DeleteAccountEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Password == "" {
+		resp := Response{
+			Message: "password missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	// The username stays the same when the email of the user changes
+	usernameFromToken, _ := typedAuthorizer["cognito:username"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	// The password is checked by logging in with it, an MFA challenge
+	// means it was correct
+	cognitoInput := cognitoidentityprovider.AdminInitiateAuthInput{
+		ClientId: aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		AuthFlow: aws.String("ADMIN_NO_SRP_AUTH"),
+		AuthParameters: map[string]*string{
+			"USERNAME": aws.String(usernameFromToken),
+			"PASSWORD": aws.String(evt.Password),
+		},
+		UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+	}
+
+	_, err = cognitoService.AdminInitiateAuth(&cognitoInput)
+	if err != nil {
+		log.Println("Error checking password (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		if statusCode == 401 {
+			message = "Incorrect password"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Account Deletion Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: statusCode,
+		}, nil
+	}
+
+	dynamoService := dynamodb.New(sess)
+	s3Service := s3.New(sess)
+
+	// An organization without an admin could never be managed again
+	lastAdminOf, err := org.LastAdminOf(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error looking up organizations (dynamo):", err)
+		resp := Response{
+			Message: "Error deleting account",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if len(lastAdminOf) > 0 {
+		resp := Response{
+			Message: fmt.Sprintf("Make another member admin of organization %s first", lastAdminOf[0].Name),
+			Error:   "Account Deletion Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	deletion, err := account.Start(dynamoService, subFromToken, usernameFromToken, time.Now())
+	if err == account.ErrCompleted {
+		resp := Response{
+			Message: "Account was already deleted",
+			Error:   "Account Deletion Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 410,
+		}, nil
+	}
+	if err != nil {
+		log.Println("Error starting account deletion (dynamo):", err)
+		resp := Response{
+			Message: "Error deleting account",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	err = account.Run(dynamoService, cognitoService, s3Service, os.Getenv("COGNITO_USER_POOL_ID"), os.Getenv("EXPORT_BUCKET"), deletion, time.Now())
+	if err != nil {
+		// The tombstone is recorded, account_deletion_resume finishes the deletion
+		log.Println("Error deleting account:", err)
+		resp := Response{
+			Message: "Account deletion started, it will finish shortly",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 202}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully deleted account",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	if os.Getenv("EXPORT_BUCKET") == "" {
+		log.Fatal("EXPORT_BUCKET not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, DeleteAccount))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/account"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"os"
+	"time"
+)
+
+// ResumeDeletions is the lambda function handler
+// it runs every five minutes and finishes the account deletions that failed halfway
+func ResumeDeletions(ctx context.Context, evt events.CloudWatchEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	cognitoService := cognitoidentityprovider.New(sess)
+	s3Service := s3.New(sess)
+
+	return account.Resume(dynamoService, cognitoService, s3Service, os.Getenv("COGNITO_USER_POOL_ID"), os.Getenv("EXPORT_BUCKET"), time.Now())
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	if os.Getenv("EXPORT_BUCKET") == "" {
+		log.Fatal("EXPORT_BUCKET not set")
+	}
+
+	lambda.Start(ResumeDeletions)
+}
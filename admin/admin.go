@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no user matches a lookup
+var ErrNotFound = errors.New("not found")
+
+// User is a cognito user as support sees it
+type User struct {
+	// UserID is the cognito sub of the user
+	UserID string `json:"userID"`
+	// Username is the cognito username the admin actions of cognito take
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"emailVerified"`
+	Status        string   `json:"status"`
+	Enabled       bool     `json:"enabled"`
+	CreatedAt     string   `json:"createdAt"`
+	Groups        []string `json:"groups"`
+}
+
+// FindUser returns the user whose attribute, like email or sub, has the
+// value or ErrNotFound
+// Groups are not looked up
+func FindUser(cognitoService *cognitoidentityprovider.CognitoIdentityProvider, userPoolID string, attribute string, value string) (User, error) {
+	// The value is quoted in the filter, a value that would end the quote
+	// can not belong to anyone
+	if value == "" || strings.ContainsAny(value, "\"\\") {
+		return User{}, ErrNotFound
+	}
+
+	cognitoResponse, err := cognitoService.ListUsers(&cognitoidentityprovider.ListUsersInput{
+		Filter:     aws.String(fmt.Sprintf("%s = \"%s\"", attribute, value)),
+		UserPoolId: aws.String(userPoolID),
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("error looking up user with %s %s: %v", attribute, value, err)
+	}
+	if len(cognitoResponse.Users) != 1 {
+		return User{}, ErrNotFound
+	}
+
+	cognitoUser := cognitoResponse.Users[0]
+	user := User{
+		Username: aws.StringValue(cognitoUser.Username),
+		Status:   aws.StringValue(cognitoUser.UserStatus),
+		Enabled:  aws.BoolValue(cognitoUser.Enabled),
+		Groups:   []string{},
+	}
+	if cognitoUser.UserCreateDate != nil {
+		user.CreatedAt = cognitoUser.UserCreateDate.UTC().Format(time.RFC3339)
+	}
+	for _, attribute := range cognitoUser.Attributes {
+		switch aws.StringValue(attribute.Name) {
+		case "sub":
+			user.UserID = aws.StringValue(attribute.Value)
+		case "email":
+			user.Email = aws.StringValue(attribute.Value)
+		case "email_verified":
+			user.EmailVerified = aws.StringValue(attribute.Value) == "true"
+		}
+	}
+
+	return user, nil
+}
+
+// Groups returns the cognito groups of the user with the username
+func Groups(cognitoService *cognitoidentityprovider.CognitoIdentityProvider, userPoolID string, username string) ([]string, error) {
+	groups := []string{}
+	cognitoInput := cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(userPoolID),
+		Username:   aws.String(username),
+	}
+	for {
+		cognitoResponse, err := cognitoService.AdminListGroupsForUser(&cognitoInput)
+		if err != nil {
+			return nil, fmt.Errorf("error listing groups of %s: %v", username, err)
+		}
+		for _, group := range cognitoResponse.Groups {
+			groups = append(groups, aws.StringValue(group.GroupName))
+		}
+		if cognitoResponse.NextToken == nil {
+			return groups, nil
+		}
+		cognitoInput.NextToken = cognitoResponse.NextToken
+	}
+}
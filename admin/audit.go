@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"time"
+)
+
+// Dynamo table of the actions taken through the admin API
+const (
+	// AuditTable hash key is AdminID, range key is Timestamp followed
+	// by audit.KeySeparator and a random suffix, see audit.SortKey
+	AuditTable = "admin_audit"
+	// TargetIndex is the global secondary index of AuditTable
+	// Hash key is Target, range key is the Timestamp of AuditTable
+	TargetIndex = "TargetIndex"
+)
+
+// Actions recorded in the admin audit log
+const (
+	ActionFindUser        = "FindUser"
+	ActionListDevices     = "ListDevices"
+	ActionSetDeviceStatus = "SetDeviceStatus"
+	ActionReleaseDevice   = "ReleaseDevice"
+	ActionDisableUser     = "DisableUser"
+	ActionEnableUser      = "EnableUser"
+)
+
+// Entry describes a single action of an admin
+type Entry struct {
+	// AdminID is the cognito sub of the admin taking the action
+	AdminID string `json:"adminID"`
+	// AdminEmail is the email claim of the admin taking the action
+	AdminEmail string `json:"adminEmail"`
+	Timestamp  string `json:"timestamp"`
+	Action     string `json:"action"`
+	// Target is the user or device the action is taken on, lookups by
+	// email have the email as target
+	Target   string            `json:"target"`
+	SourceIP string            `json:"sourceIP"`
+	Details  map[string]string `json:"details"`
+}
+
+// NewEntry builds an admin audit entry for the given request
+// The admin is taken from the cognito claims and the source IP
+// from the API Gateway request context
+func NewEntry(req events.APIGatewayProxyRequest, action string, target string) Entry {
+	adminID, adminEmail := audit.Actor(req)
+	return Entry{
+		AdminID:    adminID,
+		AdminEmail: adminEmail,
+		Timestamp:  time.Now().UTC().Format(audit.TimestampFormat),
+		Action:     action,
+		Target:     target,
+		SourceIP:   req.RequestContext.Identity.SourceIP,
+		Details:    map[string]string{},
+	}
+}
+
+// Record writes the entry to dynamo
+// Handlers record the entry before taking the action, so nothing is
+// done through the admin API without an entry
+func Record(dynamoService *dynamodb.DynamoDB, entry Entry) error {
+	details := make(map[string]*dynamodb.AttributeValue)
+	for name, value := range entry.Details {
+		details[name] = audit.StringAttribute(value)
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(AuditTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"AdminID":    {S: aws.String(entry.AdminID)},
+			"Timestamp":  {S: aws.String(audit.SortKey(entry.Timestamp))},
+			"Action":     {S: aws.String(entry.Action)},
+			"Target":     {S: aws.String(entry.Target)},
+			"AdminEmail": audit.StringAttribute(entry.AdminEmail),
+			"SourceIP":   audit.StringAttribute(entry.SourceIP),
+			"Details":    {M: details},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#T)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#T": aws.String("Timestamp"),
+		},
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error writing admin audit entry of %s: %v", entry.AdminID, err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/account"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"net/url"
+	"os"
+)
+
+// Response defines the response structure to this device release request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// ReleaseDevice is the lambda function handler
+// it deletes the device of a departed user along with its history, so
+// its MAC can be registered again
+func ReleaseDevice(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	mac, err := url.PathUnescape(req.PathParameters["mac"])
+	if err != nil || mac == "" {
+		resp := Response{
+			Message: "mac missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if !device.ValidMAC(mac) {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid MAC Address Provided: %s", mac),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	_, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	item, err := device.Get(dynamoService, mac)
+	if err == device.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("MAC not found: %s", mac),
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up device (dynamo)", err)
+		resp := Response{
+			Message: "Error releasing device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	owner := aws.StringValue(item["Owner"].S)
+
+	// Devices of users still able to sign in are theirs to keep, support
+	// disables the account first when it is abandoned
+	if _, isOrganization := org.OrgID(owner); !isOrganization {
+		user, err := admin.FindUser(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"), "sub", owner)
+		if err != nil && err != admin.ErrNotFound {
+			log.Println("Error looking up owner (cognito)", err)
+			resp := Response{
+				Message: "Error releasing device",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+		if err == nil && user.Enabled {
+			resp := Response{
+				Message: fmt.Sprintf("Device %s belongs to the active user %s, disable the user first", mac, owner),
+				Error:   "Conflict",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+		}
+	}
+
+	adminEntry := admin.NewEntry(req, admin.ActionReleaseDevice, mac)
+	adminEntry.Details["owner"] = owner
+	if item["OwnerEmail"] != nil {
+		adminEntry.Details["ownerEmail"] = aws.StringValue(item["OwnerEmail"].S)
+	}
+	err = admin.Record(dynamoService, adminEntry)
+	if err != nil {
+		log.Println("Error recording admin audit entry (dynamo)", err)
+		resp := Response{
+			Message: "Error releasing device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	err = account.DeleteDevice(dynamoService, mac, owner)
+	if err == account.ErrOwnerChanged {
+		resp := Response{
+			Message: fmt.Sprintf("Owner of device %s changed, try again", mac),
+			Error:   "Conflict",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+	if err != nil {
+		log.Println("Error releasing device (dynamo)", err)
+		resp := Response{
+			Message: "Error releasing device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully released device %s", mac),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 204}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AdminWrite, ReleaseDevice))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"net/url"
+	"os"
+)
+
+// DeviceStatusEvent defines the request structure of this device status request
+type DeviceStatusEvent struct {
+	Status string `json:"status"`
+	// Reason is recorded in the admin audit log
+	Reason string `json:"reason"`
+}
+
+// Response defines the response structure to this device status request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// SetDeviceStatus is the lambda function handler
+// it forces the status of any device for support staff
+func SetDeviceStatus(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	mac, err := url.PathUnescape(req.PathParameters["mac"])
+	if err != nil || mac == "" {
+		resp := Response{
+			Message: "mac missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if !device.ValidMAC(mac) {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid MAC Address Provided: %s", mac),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt DeviceStatusEvent
+	err = json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if !device.ValidStatus(evt.Status) {
+		resp := Response{
+			Message: "status can only have value 'offline', 'online' or 'maintenance'",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	_, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	item, err := device.Get(dynamoService, mac)
+	if err == device.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("MAC not found: %s", mac),
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up device (dynamo)", err)
+		resp := Response{
+			Message: "Error updating device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	adminEntry := admin.NewEntry(req, admin.ActionSetDeviceStatus, mac)
+	adminEntry.Details["owner"] = aws.StringValue(item["Owner"].S)
+	adminEntry.Details["status"] = evt.Status
+	adminEntry.Details["reason"] = evt.Reason
+	err = admin.Record(dynamoService, adminEntry)
+	if err != nil {
+		log.Println("Error recording admin audit entry (dynamo)", err)
+		resp := Response{
+			Message: "Error updating device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	previousItem, updatedItem, err := device.Apply(dynamoService, mac, device.Update{Status: evt.Status})
+	if err != nil {
+		log.Println("Error updating device (dynamo)", err)
+		resp := Response{
+			Message: "Error updating device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	// The change is applied at this point, but it is not reported as done
+	// without its audit entry
+	auditEntry := audit.NewEntry(req, mac, audit.ActionAdminUpdateDevice)
+	auditEntry.Changes = audit.Diff(previousItem, updatedItem)
+	err = audit.Record(dynamoService, auditEntry)
+	if err != nil {
+		resp := Response{
+			Message: fmt.Sprintf("Status of device %s was set, but recording the change in its audit log failed", mac),
+			Error:   "Something went wrong",
+		}
+		log.Println("Error recording audit entry:", err)
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully set status of device %s to %s", mac, evt.Status),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 204}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AdminWrite, SetDeviceStatus))
+}
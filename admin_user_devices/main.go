@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/geo"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Device describes a device of the user
+type Device struct {
+	MAC   string `json:"mac"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// OwnerEmail is the email of the owner when the device was registered
+	OwnerEmail string        `json:"ownerEmail,omitempty"`
+	Status     string        `json:"status"`
+	Location   *geo.Location `json:"location,omitempty"`
+}
+
+// Response defines the response structure to this device listing request
+type Response struct {
+	Message string   `json:"Response"`
+	Error   string   `json:"Error"`
+	Devices []Device `json:"Devices,omitempty"`
+}
+
+// ListUserDevices is the lambda function handler
+// it lists every device of a user, or of an organization when given
+// its device owner, for support staff
+func ListUserDevices(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	owner, err := url.PathUnescape(req.PathParameters["userID"])
+	if err != nil || owner == "" {
+		resp := Response{
+			Message: "userID missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	_, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	err = admin.Record(dynamoService, admin.NewEntry(req, admin.ActionListDevices, owner))
+	if err != nil {
+		log.Println("Error recording admin audit entry (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up devices",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(device.TableName),
+		IndexName:              aws.String(device.OwnerIndex),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	devices := []Device{}
+	err = dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			devices = append(devices, deviceFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		log.Println("Error querying devices (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up devices",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Found %d devices", len(devices)),
+		Devices: devices,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+// deviceFromItem converts a dynamo item to a Device
+func deviceFromItem(item map[string]*dynamodb.AttributeValue) Device {
+	device := Device{
+		MAC:        stringValue(item["MAC"]),
+		Name:       stringValue(item["Name"]),
+		Owner:      stringValue(item["Owner"]),
+		OwnerEmail: stringValue(item["OwnerEmail"]),
+		Status:     stringValue(item["Status"]),
+	}
+
+	latitude := numberValue(item["Latitude"])
+	longitude := numberValue(item["Longitude"])
+	if latitude != nil && longitude != nil {
+		device.Location = &geo.Location{
+			Latitude:  latitude,
+			Longitude: longitude,
+			Altitude:  numberValue(item["Altitude"]),
+			Accuracy:  numberValue(item["Accuracy"]),
+		}
+	}
+
+	return device
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func numberValue(value *dynamodb.AttributeValue) *float64 {
+	if value == nil || value.N == nil {
+		return nil
+	}
+	number, err := strconv.ParseFloat(*value.N, 64)
+	if err != nil {
+		return nil
+	}
+	return &number
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AdminRead, ListUserDevices))
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// UserEnabledEvent defines the request structure of this user enabled request
+type UserEnabledEvent struct {
+	Enabled *bool `json:"enabled"`
+	// Reason is recorded in the admin audit log
+	Reason string `json:"reason"`
+}
+
+// Response defines the response structure to this user enabled request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// SetUserEnabled is the lambda function handler
+// it disables or enables the cognito user for support staff, disabling
+// also signs the user out everywhere
+func SetUserEnabled(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	userID := req.PathParameters["userID"]
+	if userID == "" {
+		resp := Response{
+			Message: "userID missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt UserEnabledEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Enabled == nil {
+		resp := Response{
+			Message: "enabled missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	// An admin locking themselves out needs someone else to undo it
+	if userID == subFromToken && !*evt.Enabled {
+		resp := Response{
+			Message: "Admins can not disable themselves",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	user, err := admin.FindUser(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"), "sub", userID)
+	if err == admin.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("User not found: %s", userID),
+			Error:   "User lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up user (cognito)", err)
+		resp := Response{
+			Message: "Error updating user",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	action := admin.ActionDisableUser
+	if *evt.Enabled {
+		action = admin.ActionEnableUser
+	}
+	adminEntry := admin.NewEntry(req, action, userID)
+	adminEntry.Details["email"] = user.Email
+	adminEntry.Details["reason"] = evt.Reason
+	err = admin.Record(dynamoService, adminEntry)
+	if err != nil {
+		log.Println("Error recording admin audit entry (dynamo)", err)
+		resp := Response{
+			Message: "Error updating user",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	message := fmt.Sprintf("Successfully enabled user %s", userID)
+	if *evt.Enabled {
+		_, err = cognitoService.AdminEnableUser(&cognitoidentityprovider.AdminEnableUserInput{
+			UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+			Username:   aws.String(user.Username),
+		})
+		if err != nil {
+			log.Println("Error enabling user (cognito)", err)
+			resp := Response{
+				Message: "Error updating user",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+	} else {
+		_, err = cognitoService.AdminDisableUser(&cognitoidentityprovider.AdminDisableUserInput{
+			UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+			Username:   aws.String(user.Username),
+		})
+		if err != nil {
+			log.Println("Error disabling user (cognito)", err)
+			resp := Response{
+				Message: "Error updating user",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+
+		// Disabling stops new sign ins, signing the user out everywhere
+		// revokes the refresh tokens and the recorded sign out makes the
+		// API authorizer refuse the ID tokens already issued
+		_, err = cognitoService.AdminUserGlobalSignOut(&cognitoidentityprovider.AdminUserGlobalSignOutInput{
+			UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+			Username:   aws.String(user.Username),
+		})
+		if err != nil {
+			log.Println("Error signing out user (cognito)", err)
+			resp := Response{
+				Message: "User disabled but not signed out, disable again to retry",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+		err = auth.RecordSignOut(dynamoService, userID, time.Now())
+		if err != nil {
+			log.Println("Error recording sign out (dynamo)", err)
+			resp := Response{
+				Message: "User disabled but not signed out, disable again to retry",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+
+		message = fmt.Sprintf("Successfully disabled user %s", userID)
+	}
+
+	resp := Response{
+		Message: message,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AdminWrite, SetUserEnabled))
+}
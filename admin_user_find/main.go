@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// Response defines the response structure to this user lookup request
+type Response struct {
+	Message string      `json:"Response"`
+	Error   string      `json:"Error"`
+	User    *admin.User `json:"User,omitempty"`
+}
+
+// FindUser is the lambda function handler
+// it looks up the user with the email for support staff
+func FindUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	email := auth.NormalizeEmail(req.QueryStringParameters["email"])
+	if email == "" {
+		resp := Response{
+			Message: "email missing from request query string",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	_, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	err := admin.Record(dynamoService, admin.NewEntry(req, admin.ActionFindUser, email))
+	if err != nil {
+		log.Println("Error recording admin audit entry (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up user",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	user, err := admin.FindUser(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"), "email", email)
+	if err == admin.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("No user with email %s", email),
+			Error:   "User lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up user (cognito)", err)
+		resp := Response{
+			Message: "Error looking up user",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	user.Groups, err = admin.Groups(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"), user.Username)
+	if err != nil {
+		log.Println("Error looking up groups (cognito)", err)
+		resp := Response{
+			Message: "Error looking up user",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Found user %s", user.UserID),
+		User:    &user,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AdminRead, FindUser))
+}
@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"time"
+)
+
+// Observe evaluates every rule that covers the device against the
+// observation and records alerts that fire or resolve
+// Devices of an organization are covered by the rules of its members
+// that list the device
+func Observe(dynamoService *dynamodb.DynamoDB, owner string, mac string, observation Observation, now time.Time) error {
+	users, err := org.Users(dynamoService, owner)
+	if err != nil {
+		return err
+	}
+	_, orgDevice := org.OrgID(owner)
+
+	var rules []Rule
+	for _, userID := range users {
+		userRules, err := ListRules(dynamoService, userID)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, userRules...)
+	}
+
+	for _, rule := range rules {
+		if !rule.AppliesTo(mac) || (orgDevice && len(rule.Devices) == 0) {
+			continue
+		}
+		met, value, ok := rule.Condition(observation)
+		if !ok {
+			continue
+		}
+
+		state, err := GetState(dynamoService, rule.RuleID, mac)
+		if err != nil {
+			return err
+		}
+
+		err = apply(dynamoService, rule, state, met, value, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tick fires the pending alerts whose condition held long enough
+// Rules are only evaluated when data comes in, so without this an
+// "offline for 60 minutes" alert would never fire
+func Tick(dynamoService *dynamodb.DynamoDB, now time.Time) error {
+	states, err := DueStates(dynamoService, now)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		rule, err := GetRule(dynamoService, state.Owner, state.RuleID)
+		if err == ErrNotFound || (err == nil && !rule.AppliesTo(state.MAC)) {
+			err = DeleteState(dynamoService, state.RuleID, state.MAC)
+			if err != nil {
+				log.Println("Error deleting stale alert state:", err)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Any observation not meeting the condition would have moved the
+		// state out of pending, so the condition is still met
+		err = apply(dynamoService, rule, state, true, state.Value, now)
+		if err == ErrConflict {
+			// An observation came in since the state was listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func apply(dynamoService *dynamodb.DynamoDB, rule Rule, state State, met bool, value string, now time.Time) error {
+	next, transition, changed := Evaluate(rule, state, met, value, now)
+	if !changed {
+		return nil
+	}
+
+	err := PutState(dynamoService, next)
+	if err != nil {
+		return err
+	}
+
+	if transition != "" {
+		return PutHistory(dynamoService, NewHistoryEntry(rule, next, transition, now))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package alert
+
+import (
+	"time"
+)
+
+// Alert states of a rule for a single device
+const (
+	StateOK = "ok"
+	// StatePending means the condition is met but has not held
+	// for the duration of the rule yet
+	StatePending = "pending"
+	StateFiring  = "firing"
+)
+
+// Transitions recorded in the alert history
+const (
+	TransitionFired    = "fired"
+	TransitionResolved = "resolved"
+)
+
+// TimestampFormat is fixed width so that history entries sort
+// lexicographically in the order they were recorded
+const TimestampFormat = "2006-01-02T15:04:05.000000Z"
+
+// State tracks a rule for a single device
+// Firing alerts stay firing until the condition is no longer met,
+// which is what keeps an alert from being repeated
+type State struct {
+	RuleID string
+	MAC    string
+	Owner  string
+	State  string
+	// Since is when the condition was first met
+	Since string
+	// DueAt is when a pending alert fires
+	DueAt string
+	// Value is the value the condition was last met with
+	Value     string
+	UpdatedAt string
+	// Version guards against concurrent evaluations
+	Version int
+}
+
+// Evaluate returns the state after observing whether the condition of the
+// rule is met at now and the transition to record, if any
+// changed is false when nothing needs to be stored
+func Evaluate(rule Rule, state State, met bool, value string, now time.Time) (next State, transition string, changed bool) {
+	next = state
+	next.RuleID = rule.RuleID
+	next.Owner = rule.Owner
+	next.UpdatedAt = now.UTC().Format(TimestampFormat)
+	if next.State == "" {
+		next.State = StateOK
+	}
+
+	if !met {
+		if next.State == StateOK {
+			return state, "", false
+		}
+		resolved := next.State == StateFiring
+		next.State = StateOK
+		next.Since = ""
+		next.DueAt = ""
+		if resolved {
+			return next, TransitionResolved, true
+		}
+		return next, "", true
+	}
+
+	next.Value = value
+
+	switch next.State {
+	case StateOK:
+		next.Since = now.UTC().Format(TimestampFormat)
+		if rule.ForMinutes == 0 {
+			next.State = StateFiring
+			return next, TransitionFired, true
+		}
+		next.State = StatePending
+		next.DueAt = now.Add(time.Duration(rule.ForMinutes) * time.Minute).UTC().Format(TimestampFormat)
+		return next, "", true
+
+	case StatePending:
+		due, err := time.Parse(TimestampFormat, next.DueAt)
+		if err == nil && now.Before(due) {
+			// Only the value changed, which is not worth a write
+			return state, "", false
+		}
+		next.State = StateFiring
+		next.DueAt = ""
+		return next, TransitionFired, true
+	}
+
+	// Already firing
+	return state, "", false
+}
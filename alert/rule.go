@@ -0,0 +1,146 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Rule types
+const (
+	// TypeMetric compares a telemetry metric against the threshold
+	TypeMetric = "metric"
+	// TypeOffline is met while the device is offline
+	TypeOffline = "offline"
+)
+
+// Limits on rules
+const (
+	MaxRules      = 50
+	MaxDevices    = 100
+	MaxForMinutes = 7 * 24 * 60
+)
+
+var (
+	macPattern    = regexp.MustCompile("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$")
+	metricPattern = regexp.MustCompile("^[A-Za-z0-9_.-]{1,64}$")
+)
+
+// Rule is an alert rule defined by a user, like
+// "battery < 15 for 10 minutes" or "offline for 60 minutes"
+type Rule struct {
+	RuleID string `json:"id"`
+	Owner  string `json:"owner"`
+	Name   string `json:"name"`
+	// Devices is the group of devices the rule applies to, every
+	// device the owner owns themselves when empty
+	// Devices of an organization only count when listed
+	Devices   []string `json:"devices"`
+	Type      string   `json:"type"`
+	Metric    string   `json:"metric,omitempty"`
+	Operator  string   `json:"operator,omitempty"`
+	Threshold float64  `json:"threshold,omitempty"`
+	// ForMinutes is how long the condition has to hold before
+	// the alert fires, right away when 0
+	ForMinutes int    `json:"forMinutes"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
+}
+
+// Validate returns an error describing the first invalid field
+// The message is meant to be returned to the user as is
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name missing from request JSON")
+	}
+	if utf8.RuneCountInString(r.Name) > 50 {
+		return errors.New("Provided name too long")
+	}
+
+	if len(r.Devices) > MaxDevices {
+		return fmt.Errorf("A rule can apply to at most %d devices", MaxDevices)
+	}
+	for _, mac := range r.Devices {
+		if !macPattern.MatchString(mac) {
+			return fmt.Errorf("Invalid MAC Address Provided: %s", mac)
+		}
+	}
+
+	switch r.Type {
+	case TypeMetric:
+		if !metricPattern.MatchString(r.Metric) {
+			return errors.New("metric missing or invalid")
+		}
+		switch r.Operator {
+		case "<", "<=", ">", ">=":
+		default:
+			return errors.New("operator can only have value '<', '<=', '>' or '>='")
+		}
+	case TypeOffline:
+		if r.Metric != "" || r.Operator != "" {
+			return errors.New("offline rules take no metric or operator")
+		}
+	default:
+		return errors.New("type can only have value 'metric' or 'offline'")
+	}
+
+	if r.ForMinutes < 0 || r.ForMinutes > MaxForMinutes {
+		return fmt.Errorf("forMinutes must be between 0 and %d", MaxForMinutes)
+	}
+
+	return nil
+}
+
+// AppliesTo reports whether the rule covers the device
+func (r Rule) AppliesTo(mac string) bool {
+	if len(r.Devices) == 0 {
+		return true
+	}
+	for _, device := range r.Devices {
+		if device == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// Observation is what is known about a device after a status
+// change or a telemetry message
+type Observation struct {
+	// Status is empty for telemetry
+	Status  string
+	Metrics map[string]float64
+}
+
+// Condition reports whether the observation meets the condition of the
+// rule and the value it was met with
+// ok is false when the observation says nothing about the rule, like
+// telemetry without the metric of the rule
+func (r Rule) Condition(observation Observation) (met bool, value string, ok bool) {
+	switch r.Type {
+	case TypeOffline:
+		if observation.Status == "" {
+			return false, "", false
+		}
+		return observation.Status == "offline", observation.Status, true
+
+	case TypeMetric:
+		metric, found := observation.Metrics[r.Metric]
+		if !found {
+			return false, "", false
+		}
+		value = fmt.Sprintf("%g", metric)
+		switch r.Operator {
+		case "<":
+			return metric < r.Threshold, value, true
+		case "<=":
+			return metric <= r.Threshold, value, true
+		case ">":
+			return metric > r.Threshold, value, true
+		case ">=":
+			return metric >= r.Threshold, value, true
+		}
+	}
+	return false, "", false
+}
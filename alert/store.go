@@ -0,0 +1,434 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// Dynamo tables backing the alerts
+const (
+	// RulesTable hash key is Owner, range key is RuleID
+	RulesTable = "alert_rules"
+	// StatesTable hash key is RuleID, range key is MAC
+	StatesTable = "alert_states"
+	// HistoryTable hash key is Owner, range key is HistoryID
+	HistoryTable = "alert_history"
+	// DueIndex is a sparse index of StatesTable holding pending alerts
+	// Hash key is Pending, range key is DueAt
+	DueIndex = "DueIndex"
+)
+
+// historyRetention is how long the alert history is kept
+const historyRetention = 90 * 24 * time.Hour
+
+// ErrNotFound is returned when a rule does not exist
+var ErrNotFound = errors.New("rule not found")
+
+// ErrConflict is returned when the state was changed by a
+// concurrent evaluation
+var ErrConflict = errors.New("state changed concurrently")
+
+// HistoryEntry records an alert firing or resolving
+type HistoryEntry struct {
+	// HistoryID sorts in the order the entries were recorded
+	HistoryID  string `json:"id"`
+	Owner      string `json:"-"`
+	RuleID     string `json:"ruleID"`
+	RuleName   string `json:"ruleName"`
+	MAC        string `json:"mac"`
+	Transition string `json:"transition"`
+	Value      string `json:"value,omitempty"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// NewHistoryEntry returns the history entry of a transition
+func NewHistoryEntry(rule Rule, state State, transition string, now time.Time) HistoryEntry {
+	timestamp := now.UTC().Format(TimestampFormat)
+	return HistoryEntry{
+		HistoryID:  timestamp + "#" + rule.RuleID + "#" + state.MAC,
+		Owner:      rule.Owner,
+		RuleID:     rule.RuleID,
+		RuleName:   rule.Name,
+		MAC:        state.MAC,
+		Transition: transition,
+		Value:      state.Value,
+		Timestamp:  timestamp,
+	}
+}
+
+// PutRule creates or replaces the rule
+func PutRule(dynamoService *dynamodb.DynamoDB, rule Rule) error {
+	// TODO: dynamodbattribute.MarshalMap does not work!? Need to figure out why, for now, we'll make the
+	// required structs manually
+	item := map[string]*dynamodb.AttributeValue{
+		"Owner":      {S: aws.String(rule.Owner)},
+		"RuleID":     {S: aws.String(rule.RuleID)},
+		"Name":       {S: aws.String(rule.Name)},
+		"Type":       {S: aws.String(rule.Type)},
+		"ForMinutes": {N: aws.String(strconv.Itoa(rule.ForMinutes))},
+		"CreatedAt":  {S: aws.String(rule.CreatedAt)},
+		"UpdatedAt":  {S: aws.String(rule.UpdatedAt)},
+	}
+	if len(rule.Devices) > 0 {
+		item["Devices"] = &dynamodb.AttributeValue{SS: aws.StringSlice(rule.Devices)}
+	}
+	if rule.Type == TypeMetric {
+		item["Metric"] = &dynamodb.AttributeValue{S: aws.String(rule.Metric)}
+		item["Operator"] = &dynamodb.AttributeValue{S: aws.String(rule.Operator)}
+		item["Threshold"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(rule.Threshold, 'f', -1, 64))}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(RulesTable),
+		Item:      item,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing rule %s: %v", rule.RuleID, err)
+	}
+	return nil
+}
+
+// UnauthorizedDevice returns the first device of the rule its owner can
+// not see, empty when the owner can see every one of them
+// Devices that are not registered count as not seen
+func UnauthorizedDevice(dynamoService *dynamodb.DynamoDB, rule Rule) (string, error) {
+	for _, mac := range rule.Devices {
+		item, err := device.Get(dynamoService, mac)
+		if err == device.ErrNotFound {
+			return mac, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		authorized, err := org.Authorize(dynamoService, rule.Owner, stringValue(item["Owner"]), org.RoleViewer)
+		if err != nil {
+			return "", err
+		}
+		if !authorized {
+			return mac, nil
+		}
+	}
+	return "", nil
+}
+
+// GetRule returns the rule or ErrNotFound
+func GetRule(dynamoService *dynamodb.DynamoDB, owner string, ruleID string) (Rule, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(RulesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":  {S: aws.String(owner)},
+			"RuleID": {S: aws.String(ruleID)},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Rule{}, fmt.Errorf("error looking up rule %s: %v", ruleID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Rule{}, ErrNotFound
+	}
+
+	return ruleFromItem(dynamoResponse.Item), nil
+}
+
+// ListRules returns every rule of the owner
+func ListRules(dynamoService *dynamodb.DynamoDB, owner string) ([]Rule, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(RulesTable),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	rules := []Rule{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			rules = append(rules, ruleFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing rules of %s: %v", owner, err)
+	}
+
+	return rules, nil
+}
+
+// DeleteRule removes the rule or returns ErrNotFound
+// States of the rule are left to the evaluator, which drops
+// them once it finds the rule gone
+func DeleteRule(dynamoService *dynamodb.DynamoDB, owner string, ruleID string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(RulesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":  {S: aws.String(owner)},
+			"RuleID": {S: aws.String(ruleID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(RuleID)"),
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting rule %s: %v", ruleID, err)
+	}
+	return nil
+}
+
+// GetState returns the state of the rule for the device, a new
+// state in StateOK if there is none
+func GetState(dynamoService *dynamodb.DynamoDB, ruleID string, mac string) (State, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(StatesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RuleID": {S: aws.String(ruleID)},
+			"MAC":    {S: aws.String(mac)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return State{}, fmt.Errorf("error looking up state of rule %s for %s: %v", ruleID, mac, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return State{RuleID: ruleID, MAC: mac, State: StateOK}, nil
+	}
+
+	return stateFromItem(dynamoResponse.Item), nil
+}
+
+// PutState stores the state unless it was changed since it was read,
+// in which case ErrConflict is returned
+func PutState(dynamoService *dynamodb.DynamoDB, state State) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"RuleID":    {S: aws.String(state.RuleID)},
+		"MAC":       {S: aws.String(state.MAC)},
+		"Owner":     {S: aws.String(state.Owner)},
+		"State":     {S: aws.String(state.State)},
+		"UpdatedAt": {S: aws.String(state.UpdatedAt)},
+		"Version":   {N: aws.String(strconv.Itoa(state.Version + 1))},
+	}
+	if state.Since != "" {
+		item["Since"] = &dynamodb.AttributeValue{S: aws.String(state.Since)}
+	}
+	if state.Value != "" {
+		item["Value"] = &dynamodb.AttributeValue{S: aws.String(state.Value)}
+	}
+	if state.State == StatePending {
+		item["DueAt"] = &dynamodb.AttributeValue{S: aws.String(state.DueAt)}
+		item["Pending"] = &dynamodb.AttributeValue{S: aws.String(StatePending)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName:           aws.String(StatesTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(RuleID) OR Version = :v"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": {N: aws.String(strconv.Itoa(state.Version))},
+		},
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("error storing state of rule %s for %s: %v", state.RuleID, state.MAC, err)
+	}
+	return nil
+}
+
+// DeleteState removes the state of a rule that no longer exists
+func DeleteState(dynamoService *dynamodb.DynamoDB, ruleID string, mac string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(StatesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RuleID": {S: aws.String(ruleID)},
+			"MAC":    {S: aws.String(mac)},
+		},
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error deleting state of rule %s for %s: %v", ruleID, mac, err)
+	}
+	return nil
+}
+
+// DueStates returns the pending alerts due at now
+func DueStates(dynamoService *dynamodb.DynamoDB, now time.Time) ([]State, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(StatesTable),
+		IndexName:              aws.String(DueIndex),
+		KeyConditionExpression: aws.String("Pending = :p AND DueAt <= :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(StatePending)},
+			":n": {S: aws.String(now.UTC().Format(TimestampFormat))},
+		},
+	}
+
+	var states []State
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			states = append(states, stateFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing due alerts: %v", err)
+	}
+
+	return states, nil
+}
+
+// PutHistory records the history entry
+func PutHistory(dynamoService *dynamodb.DynamoDB, entry HistoryEntry) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"Owner":      {S: aws.String(entry.Owner)},
+		"HistoryID":  {S: aws.String(entry.HistoryID)},
+		"RuleID":     {S: aws.String(entry.RuleID)},
+		"RuleName":   {S: aws.String(entry.RuleName)},
+		"MAC":        {S: aws.String(entry.MAC)},
+		"Transition": {S: aws.String(entry.Transition)},
+		"Timestamp":  {S: aws.String(entry.Timestamp)},
+		"ExpiresAt":  {N: aws.String(strconv.FormatInt(time.Now().Add(historyRetention).Unix(), 10))},
+	}
+	if entry.Value != "" {
+		item["Value"] = &dynamodb.AttributeValue{S: aws.String(entry.Value)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(HistoryTable),
+		Item:      item,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing alert history of rule %s: %v", entry.RuleID, err)
+	}
+	return nil
+}
+
+// ListHistory returns the alert history of the owner, newest first
+// startHistoryID continues a previous listing, the returned string
+// is the entry to continue from or empty if there are no more
+func ListHistory(dynamoService *dynamodb.DynamoDB, owner string, limit int64, startHistoryID string) ([]HistoryEntry, string, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(HistoryTable),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(limit),
+	}
+
+	if startHistoryID != "" {
+		dynamoInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"HistoryID": {S: aws.String(startHistoryID)},
+		}
+	}
+
+	dynamoResponse, err := dynamoService.Query(&dynamoInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("error querying alert history of %s: %v", owner, err)
+	}
+
+	entries := make([]HistoryEntry, 0, len(dynamoResponse.Items))
+	for _, item := range dynamoResponse.Items {
+		entries = append(entries, HistoryEntry{
+			HistoryID:  stringValue(item["HistoryID"]),
+			Owner:      stringValue(item["Owner"]),
+			RuleID:     stringValue(item["RuleID"]),
+			RuleName:   stringValue(item["RuleName"]),
+			MAC:        stringValue(item["MAC"]),
+			Transition: stringValue(item["Transition"]),
+			Value:      stringValue(item["Value"]),
+			Timestamp:  stringValue(item["Timestamp"]),
+		})
+	}
+
+	var next string
+	if dynamoResponse.LastEvaluatedKey != nil {
+		next = stringValue(dynamoResponse.LastEvaluatedKey["HistoryID"])
+	}
+
+	return entries, next, nil
+}
+
+func ruleFromItem(item map[string]*dynamodb.AttributeValue) Rule {
+	rule := Rule{
+		RuleID:    stringValue(item["RuleID"]),
+		Owner:     stringValue(item["Owner"]),
+		Name:      stringValue(item["Name"]),
+		Devices:   []string{},
+		Type:      stringValue(item["Type"]),
+		Metric:    stringValue(item["Metric"]),
+		Operator:  stringValue(item["Operator"]),
+		CreatedAt: stringValue(item["CreatedAt"]),
+		UpdatedAt: stringValue(item["UpdatedAt"]),
+	}
+	if item["Devices"] != nil {
+		rule.Devices = aws.StringValueSlice(item["Devices"].SS)
+	}
+	if item["Threshold"] != nil && item["Threshold"].N != nil {
+		rule.Threshold, _ = strconv.ParseFloat(*item["Threshold"].N, 64)
+	}
+	if item["ForMinutes"] != nil && item["ForMinutes"].N != nil {
+		rule.ForMinutes, _ = strconv.Atoi(*item["ForMinutes"].N)
+	}
+	return rule
+}
+
+func stateFromItem(item map[string]*dynamodb.AttributeValue) State {
+	state := State{
+		RuleID:    stringValue(item["RuleID"]),
+		MAC:       stringValue(item["MAC"]),
+		Owner:     stringValue(item["Owner"]),
+		State:     stringValue(item["State"]),
+		Since:     stringValue(item["Since"]),
+		DueAt:     stringValue(item["DueAt"]),
+		Value:     stringValue(item["Value"]),
+		UpdatedAt: stringValue(item["UpdatedAt"]),
+	}
+	if item["Version"] != nil && item["Version"].N != nil {
+		state.Version, _ = strconv.Atoi(*item["Version"].N)
+	}
+	return state
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
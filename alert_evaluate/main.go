@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/devicestream"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/ingest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EvaluateAlerts is the lambda function handler
+// it consumes the devices and device_telemetry table streams and
+// evaluates the alert rules of the owner against every status change
+// and telemetry message
+func EvaluateAlerts(ctx context.Context, evt events.DynamoDBEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	for _, record := range evt.Records {
+		if record.EventName == "REMOVE" {
+			continue
+		}
+
+		var mac, owner string
+		var observation alert.Observation
+
+		if strings.Contains(record.EventSourceArn, ":table/"+ingest.TelemetryTable+"/") {
+			mac = record.Change.NewImage["MAC"].String()
+			observation.Metrics = make(map[string]float64)
+			for name, value := range record.Change.NewImage["Metrics"].Map() {
+				metric, err := strconv.ParseFloat(value.Number(), 64)
+				if err == nil {
+					observation.Metrics[name] = metric
+				}
+			}
+
+			// Telemetry does not carry the owner of the device
+			dynamoInput := dynamodb.GetItemInput{
+				TableName: aws.String("devices"),
+				Key: map[string]*dynamodb.AttributeValue{
+					"MAC": {S: aws.String(mac)},
+				},
+				ProjectionExpression: aws.String("#O"),
+				ExpressionAttributeNames: map[string]*string{
+					"#O": aws.String("Owner"),
+				},
+			}
+			dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+			if err != nil {
+				// Returning the error makes lambda retry the batch
+				return fmt.Errorf("error looking up owner of %s: %v", mac, err)
+			}
+			owner = devicestream.String(dynamoResponse.Item, "Owner")
+		} else {
+			oldItem := devicestream.Item(record.Change.OldImage)
+			newItem := devicestream.Item(record.Change.NewImage)
+
+			observation.Status = devicestream.String(newItem, "Status")
+			if observation.Status == devicestream.String(oldItem, "Status") {
+				continue
+			}
+			mac = devicestream.String(newItem, "MAC")
+			owner = devicestream.String(newItem, "Owner")
+		}
+
+		if owner == "" {
+			continue
+		}
+
+		err := alert.Observe(dynamoService, owner, mac, observation, time.Now())
+		if err == alert.ErrConflict {
+			// The scheduled evaluation changed a state in between,
+			// retrying the batch evaluates against its result
+			log.Printf("Alert state of %s changed concurrently, retrying\n", mac)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(EvaluateAlerts)
+}
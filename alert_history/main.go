@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// Response defines the response structure to this alert history request
+type Response struct {
+	Message string               `json:"Response"`
+	Error   string               `json:"Error"`
+	Entries []alert.HistoryEntry `json:"Entries,omitempty"`
+	// Next is passed back as the next query string parameter
+	// to fetch the following page of entries
+	Next string `json:"Next,omitempty"`
+}
+
+// GetAlertHistory is the lambda function handler
+// it returns the alerts of the caller that fired or resolved, newest first
+func GetAlertHistory(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	limit := audit.ParseLimit(req.QueryStringParameters["limit"])
+
+	entries, next, err := alert.ListHistory(dynamoService, subFromToken, limit, req.QueryStringParameters["next"])
+	if err != nil {
+		log.Println("Error listing alert history (dynamo)", err)
+		resp := Response{
+			Message: "Error retrieving alert history",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Found %d alert history entries", len(entries)),
+		Entries: entries,
+		Next:    next,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AlertRead, GetAlertHistory))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// RuleCreateEvent defines the request structure of this rule creation request
+type RuleCreateEvent struct {
+	Name string `json:"name"`
+	// Devices defaults to every device of the user
+	Devices []string `json:"devices"`
+	// Type is metric or offline
+	Type       string  `json:"type"`
+	Metric     string  `json:"metric"`
+	Operator   string  `json:"operator"`
+	Threshold  float64 `json:"threshold"`
+	ForMinutes int     `json:"forMinutes"`
+}
+
+// Response defines the response structure to this rule creation request
+type Response struct {
+	Message string      `json:"Response"`
+	Error   string      `json:"Error"`
+	Rule    *alert.Rule `json:"Rule,omitempty"`
+}
+
+// CreateRule is the lambda function handler
+func CreateRule(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt RuleCreateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	rule := alert.Rule{
+		RuleID:     webhook.NewID(),
+		Name:       evt.Name,
+		Devices:    evt.Devices,
+		Type:       evt.Type,
+		Metric:     evt.Metric,
+		Operator:   evt.Operator,
+		Threshold:  evt.Threshold,
+		ForMinutes: evt.ForMinutes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if rule.Devices == nil {
+		rule.Devices = []string{}
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		resp := Response{
+			Message: err.Error(),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	rule.Owner = subFromToken
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	unauthorizedDevice, err := alert.UnauthorizedDevice(dynamoService, rule)
+	if err != nil {
+		log.Println("Error looking up devices of rule (dynamo)", err)
+		resp := Response{
+			Message: "Error creating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if unauthorizedDevice != "" {
+		resp := Response{
+			Message: fmt.Sprintf("Not authorized to watch device %s", unauthorizedDevice),
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	existing, err := alert.ListRules(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing rules (dynamo)", err)
+		resp := Response{
+			Message: "Error creating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(existing) >= alert.MaxRules {
+		resp := Response{
+			Message: fmt.Sprintf("A user can have at most %d alert rules", alert.MaxRules),
+			Error:   "Limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	err = alert.PutRule(dynamoService, rule)
+	if err != nil {
+		log.Println("Error creating rule (dynamo)", err)
+		resp := Response{
+			Message: "Error creating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully created alert rule %s", rule.RuleID),
+		Rule:    &rule,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AlertWrite, CreateRule))
+}
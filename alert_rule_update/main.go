@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// RuleUpdateEvent defines the request structure of this rule update request
+// The rule is replaced as a whole
+type RuleUpdateEvent struct {
+	Name string `json:"name"`
+	// Devices defaults to every device of the user
+	Devices []string `json:"devices"`
+	// Type is metric or offline
+	Type       string  `json:"type"`
+	Metric     string  `json:"metric"`
+	Operator   string  `json:"operator"`
+	Threshold  float64 `json:"threshold"`
+	ForMinutes int     `json:"forMinutes"`
+}
+
+// Response defines the response structure to this rule update request
+type Response struct {
+	Message string      `json:"Response"`
+	Error   string      `json:"Error"`
+	Rule    *alert.Rule `json:"Rule,omitempty"`
+}
+
+// UpdateRule is the lambda function handler
+// Alerts already firing for the rule stay firing until the
+// next observation no longer meets the updated condition
+func UpdateRule(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	ruleID := req.PathParameters["id"]
+	if ruleID == "" {
+		resp := Response{
+			Message: "id missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt RuleUpdateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	rule := alert.Rule{
+		RuleID:     ruleID,
+		Name:       evt.Name,
+		Devices:    evt.Devices,
+		Type:       evt.Type,
+		Metric:     evt.Metric,
+		Operator:   evt.Operator,
+		Threshold:  evt.Threshold,
+		ForMinutes: evt.ForMinutes,
+		UpdatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if rule.Devices == nil {
+		rule.Devices = []string{}
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		resp := Response{
+			Message: err.Error(),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	rule.Owner = subFromToken
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	unauthorizedDevice, err := alert.UnauthorizedDevice(dynamoService, rule)
+	if err != nil {
+		log.Println("Error looking up devices of rule (dynamo)", err)
+		resp := Response{
+			Message: "Error updating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if unauthorizedDevice != "" {
+		resp := Response{
+			Message: fmt.Sprintf("Not authorized to watch device %s", unauthorizedDevice),
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	existing, err := alert.GetRule(dynamoService, subFromToken, ruleID)
+	if err == alert.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("Alert rule not found: %s", ruleID),
+			Error:   "Rule lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up rule (dynamo)", err)
+		resp := Response{
+			Message: "Error updating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	rule.CreatedAt = existing.CreatedAt
+
+	err = alert.PutRule(dynamoService, rule)
+	if err != nil {
+		log.Println("Error updating rule (dynamo)", err)
+		resp := Response{
+			Message: "Error updating alert rule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully updated alert rule %s", rule.RuleID),
+		Rule:    &rule,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AlertWrite, UpdateRule))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// FireDueAlerts is the lambda function handler
+// it runs on a schedule and fires pending alerts whose condition
+// has held for as long as their rule asks for
+func FireDueAlerts(ctx context.Context, evt events.CloudWatchEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	return alert.Tick(dynamoService, time.Now())
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(FireDueAlerts)
+}
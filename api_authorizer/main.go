@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/apikey"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/idtoken"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// errUnauthorized makes API gateway answer with a 401
+var errUnauthorized = errors.New("Unauthorized")
+
+// verifier is kept across invocations so the user pool keys
+// are only fetched once per container
+var verifier *idtoken.Verifier
+
+// Authorize is the lambda function handler of the API authorizer
+// it accepts a cognito ID token or an API key in the X-HERMES-CLOUD-TOKEN
+// header and hands the handlers the claims of either
+func Authorize(ctx context.Context, req events.APIGatewayCustomAuthorizerRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+
+	token := req.AuthorizationToken
+	if token == "" {
+		return events.APIGatewayCustomAuthorizerResponse{}, errUnauthorized
+	}
+
+	var claims map[string]interface{}
+	var err error
+	if apikey.IsKey(token) {
+		claims, err = keyClaims(token)
+	} else {
+		claims, err = tokenClaims(ctx, token)
+	}
+	if err != nil {
+		if err != errUnauthorized {
+			log.Println("Error authorizing request", err)
+		}
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+
+	encodedClaims, err := json.Marshal(claims)
+	if err != nil {
+		log.Println("Error marshalling claims:", err)
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+
+	subFromToken, _ := claims["sub"].(string)
+
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: subFromToken,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{
+				{
+					Action:   []string{"execute-api:Invoke"},
+					Effect:   "Allow",
+					Resource: []string{stageArn(req.MethodArn)},
+				},
+			},
+		},
+		Context: map[string]interface{}{
+			"claims": string(encodedClaims),
+		},
+	}, nil
+}
+
+// tokenClaims verifies the cognito ID token and returns its claims
+// Tokens of sessions started before the user signed out everywhere, or
+// was disabled, are refused although cognito still considers them valid
+func tokenClaims(ctx context.Context, token string) (map[string]interface{}, error) {
+	claims, err := verifier.Verify(ctx, token)
+	if err == idtoken.ErrInvalidToken {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	signedOut, err := auth.SignedOut(dynamoService, token)
+	if err != nil {
+		return nil, err
+	}
+	if signedOut {
+		return nil, errUnauthorized
+	}
+	return claims, nil
+}
+
+// keyClaims verifies the API key and returns the claims of its user
+// Keys of users that were disabled or deleted since are refused
+func keyClaims(token string) (map[string]interface{}, error) {
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	now := time.Now()
+	key, err := apikey.Verify(dynamoService, token, now)
+	if err == apikey.ErrInvalidKey {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cognitoResponse, err := cognitoService.AdminGetUser(&cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+		Username:   aws.String(key.Username),
+	})
+	if err != nil {
+		if auth.Code(err) == cognitoidentityprovider.ErrCodeUserNotFoundException {
+			return nil, errUnauthorized
+		}
+		return nil, err
+	}
+	if !aws.BoolValue(cognitoResponse.Enabled) {
+		return nil, errUnauthorized
+	}
+
+	var email string
+	for _, attribute := range cognitoResponse.UserAttributes {
+		if aws.StringValue(attribute.Name) == "email" {
+			email = aws.StringValue(attribute.Value)
+		}
+	}
+
+	// The groups are looked up on every use so a key never has more
+	// than the roles its user has now
+	groups, err := admin.Groups(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"), key.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only informs the user, the request goes on without it
+	err = apikey.Touch(dynamoService, key.KeyID, now)
+	if err != nil {
+		log.Println("Error recording use of API key (dynamo)", err)
+	}
+
+	return key.Claims(email, groups), nil
+}
+
+// stageArn returns the arn of every method of the stage the method
+// belongs to
+// API gateway caches the policy for the token across methods, so it
+// has to allow all of them
+func stageArn(methodArn string) string {
+	// arn:aws:execute-api:region:account:apiID/stage/method/path
+	parts := strings.SplitN(methodArn, "/", 3)
+	if len(parts) < 2 {
+		return methodArn
+	}
+	return parts[0] + "/" + parts[1] + "/*"
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	verifier = &idtoken.Verifier{
+		Region:     os.Getenv("AWS_REGION"),
+		UserPoolID: os.Getenv("COGNITO_USER_POOL_ID"),
+		ClientID:   os.Getenv("COGNITO_APP_CLIENT_ID"),
+	}
+
+	lambda.Start(Authorize)
+}
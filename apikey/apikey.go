@@ -0,0 +1,293 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+	"time"
+)
+
+// Dynamo table of the API keys
+const (
+	// TableName hash key is KeyID
+	TableName = "api_keys"
+	// UserIndex is the global secondary index of TableName
+	// Hash key is userID, range key is KeyID
+	UserIndex = "UserIndex"
+)
+
+// Prefix starts every API key, the KeyID and the secret follow it
+// separated by an underscore, like hck_<KeyID>_<secret>
+const Prefix = "hck_"
+
+// TokenUse is the token_use claim of requests made with an API key
+const TokenUse = "api_key"
+
+// KeyIDClaim is the claim holding the id of the API key a request was made with
+const KeyIDClaim = "hermes:api_key_id"
+
+// MaxKeys is the most active keys a user can have
+const MaxKeys = 20
+
+// MaxExpiryDays is the longest an API key can be valid
+const MaxExpiryDays = 365
+
+// ErrNotFound is returned when the user has no key with the id
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidKey is returned for keys that are malformed, unknown,
+// revoked or expired
+var ErrInvalidKey = errors.New("invalid API key")
+
+// APIKey is an API key of a user, only the hash of its secret is stored
+type APIKey struct {
+	KeyID  string `json:"id"`
+	UserID string `json:"-"`
+	// Username is the cognito username, used to check the user is
+	// still enabled whenever the key is used
+	Username string   `json:"-"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Hash     string   `json:"-"`
+	// CreatedAt, ExpiresAt, RevokedAt and LastUsedAt are RFC3339
+	CreatedAt  string `json:"createdAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	RevokedAt  string `json:"revokedAt,omitempty"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+// New returns a key valid until expiresAt along with the key string,
+// which is shown to the user once and not stored
+func New(userID string, username string, name string, scopes []string, now time.Time, expiresAt time.Time) (APIKey, string) {
+	keyID := randomHex(16)
+	secret := randomHex(32)
+
+	key := APIKey{
+		KeyID:     keyID,
+		UserID:    userID,
+		Username:  username,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hash(secret),
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}
+	return key, Prefix + keyID + "_" + secret
+}
+
+// IsKey reports whether the token is an API key rather than a cognito token
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Active reports whether the key can still be used
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err == nil && now.Before(expiresAt)
+}
+
+// Claims returns the claims requests made with the key carry, in the
+// shape of the claims of a cognito ID token
+// The email and groups are the current ones of the user, the scopes
+// limit the permissions the groups grant
+func (k APIKey) Claims(email string, groups []string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":              k.UserID,
+		"email":            email,
+		"cognito:username": k.Username,
+		rbac.GroupsClaim:   groups,
+		rbac.ScopesClaim:   k.Scopes,
+		"token_use":        TokenUse,
+		KeyIDClaim:         k.KeyID,
+	}
+}
+
+// Create stores the key
+func Create(dynamoService *dynamodb.DynamoDB, key APIKey) error {
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"KeyID":     {S: aws.String(key.KeyID)},
+			"userID":    {S: aws.String(key.UserID)},
+			"Username":  {S: aws.String(key.Username)},
+			"Name":      {S: aws.String(key.Name)},
+			"Scopes":    {SS: aws.StringSlice(key.Scopes)},
+			"Hash":      {S: aws.String(key.Hash)},
+			"CreatedAt": {S: aws.String(key.CreatedAt)},
+			"ExpiresAt": {S: aws.String(key.ExpiresAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(KeyID)"),
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing API key %s: %v", key.KeyID, err)
+	}
+	return nil
+}
+
+// List returns every key of the user, revoked and expired ones included
+func List(dynamoService *dynamodb.DynamoDB, userID string) ([]APIKey, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		IndexName:              aws.String(UserIndex),
+		KeyConditionExpression: aws.String("userID = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {S: aws.String(userID)},
+		},
+	}
+
+	keys := []APIKey{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			keys = append(keys, keyFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing API keys of %s: %v", userID, err)
+	}
+	return keys, nil
+}
+
+// Revoke stops the key of the user from being used, the key stays
+// listed as revoked
+func Revoke(dynamoService *dynamodb.DynamoDB, userID string, keyID string, now time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"KeyID": {S: aws.String(keyID)},
+		},
+		UpdateExpression:    aws.String("SET RevokedAt = if_not_exists(RevokedAt, :r)"),
+		ConditionExpression: aws.String("userID = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {S: aws.String(now.UTC().Format(time.RFC3339))},
+			":u": {S: aws.String(userID)},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error revoking API key %s: %v", keyID, err)
+	}
+	return nil
+}
+
+// Verify returns the stored key the key string belongs to, or
+// ErrInvalidKey when it can not be used
+func Verify(dynamoService *dynamodb.DynamoDB, token string, now time.Time) (APIKey, error) {
+	parts := strings.Split(strings.TrimPrefix(token, Prefix), "_")
+	if !IsKey(token) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"KeyID": {S: aws.String(parts[0])},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("error looking up API key %s: %v", parts[0], err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	key := keyFromItem(dynamoResponse.Item)
+	if subtle.ConstantTimeCompare([]byte(hash(parts[1])), []byte(key.Hash)) != 1 {
+		return APIKey{}, ErrInvalidKey
+	}
+	if !key.Active(now) {
+		return APIKey{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Touch records when the key was last used
+func Touch(dynamoService *dynamodb.DynamoDB, keyID string, now time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"KeyID": {S: aws.String(keyID)},
+		},
+		UpdateExpression:    aws.String("SET LastUsedAt = :l"),
+		ConditionExpression: aws.String("attribute_exists(KeyID)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {S: aws.String(now.UTC().Format(time.RFC3339))},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("error recording use of API key %s: %v", keyID, err)
+	}
+	return nil
+}
+
+// hash returns the stored form of a secret
+// The secrets are random, so a plain hash is enough to keep a leaked
+// table from being usable
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func keyFromItem(item map[string]*dynamodb.AttributeValue) APIKey {
+	key := APIKey{
+		KeyID:      stringValue(item["KeyID"]),
+		UserID:     stringValue(item["userID"]),
+		Username:   stringValue(item["Username"]),
+		Name:       stringValue(item["Name"]),
+		Scopes:     []string{},
+		Hash:       stringValue(item["Hash"]),
+		CreatedAt:  stringValue(item["CreatedAt"]),
+		ExpiresAt:  stringValue(item["ExpiresAt"]),
+		RevokedAt:  stringValue(item["RevokedAt"]),
+		LastUsedAt: stringValue(item["LastUsedAt"]),
+	}
+	if item["Scopes"] != nil {
+		key.Scopes = aws.StringValueSlice(item["Scopes"].SS)
+	}
+	return key
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
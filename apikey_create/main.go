@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/apikey"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// CreateAPIKeyEvent defines the request structure of this API key creation request
+type CreateAPIKeyEvent struct {
+	Name string `json:"name"`
+	// Scopes are the permissions the key is limited to, like device:read
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is how long the key is valid
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+// Response defines the response structure to this API key creation request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+	// Key is only ever returned here, it can not be looked up again
+	Key    string         `json:"Key,omitempty"`
+	APIKey *apikey.APIKey `json:"APIKey,omitempty"`
+}
+
+// CreateAPIKey is the lambda function handler
+// it creates an API key for the logged in user to use in scripts
+func CreateAPIKey(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt CreateAPIKeyEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Name == "" || utf8.RuneCountInString(evt.Name) > 50 {
+		resp := Response{
+			Message: "name needs to be between 1 and 50 characters",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(evt.Scopes) == 0 {
+		resp := Response{
+			Message: "scopes missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+	seen := map[string]bool{}
+	var scopes []string
+	for _, scope := range evt.Scopes {
+		if !rbac.ValidScope(scope) {
+			resp := Response{
+				Message: fmt.Sprintf("Invalid scope provided: %s", scope),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if evt.ExpiresInDays < 1 || evt.ExpiresInDays > apikey.MaxExpiryDays {
+		resp := Response{
+			Message: fmt.Sprintf("expiresInDays needs to be between 1 and %d", apikey.MaxExpiryDays),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	// A leaked key could otherwise keep itself alive
+	if typedAuthorizer["token_use"] == apikey.TokenUse {
+		resp := Response{
+			Message: "API keys can not create API keys",
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	usernameFromToken, _ := typedAuthorizer["cognito:username"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	now := time.Now()
+
+	existing, err := apikey.List(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing API keys (dynamo)", err)
+		resp := Response{
+			Message: "Error creating API key",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	var active int
+	for _, key := range existing {
+		if key.Active(now) {
+			active++
+		}
+	}
+	if active >= apikey.MaxKeys {
+		resp := Response{
+			Message: fmt.Sprintf("A user can have at most %d active API keys", apikey.MaxKeys),
+			Error:   "Conflict",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	key, secret := apikey.New(subFromToken, usernameFromToken, evt.Name, scopes, now, now.AddDate(0, 0, evt.ExpiresInDays))
+
+	err = apikey.Create(dynamoService, key)
+	if err != nil {
+		log.Println("Error creating API key (dynamo)", err)
+		resp := Response{
+			Message: "Error creating API key",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully created API key, store it now as it is not shown again",
+		Key:     secret,
+		APIKey:  &key,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, CreateAPIKey))
+}
@@ -0,0 +1,268 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TableName is the dynamo table holding the audit entries
+// Hash key is MAC, range key is Timestamp followed by KeySeparator
+// and a random suffix
+const TableName = "device_audit"
+
+// KeySeparator separates the timestamp of an entry from the random
+// suffix that keeps entries written in the same microsecond apart
+const KeySeparator = "#"
+
+// TimestampFormat is fixed width so that entries sort
+// lexicographically in the order they were written
+const TimestampFormat = "2006-01-02T15:04:05.000000Z"
+
+// Actions recorded in the audit log
+const (
+	ActionCreateDevice = "CreateDevice"
+	ActionUpdateDevice = "UpdateDevice"
+	// ActionReportStatus is a status reported by the device itself
+	ActionReportStatus = "ReportStatus"
+	// ActionAdminUpdateDevice is an update made by support through the admin API
+	ActionAdminUpdateDevice = "AdminUpdateDevice"
+)
+
+// Change holds the value of a single device attribute
+// before and after a mutation
+type Change struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Entry describes a single audit record of a device mutation
+type Entry struct {
+	MAC       string `json:"mac"`
+	Timestamp string `json:"timestamp"`
+	Action    string `json:"action"`
+	// ActorID is the cognito sub of the user performing the action
+	ActorID string `json:"actorID"`
+	// ActorEmail is the email claim of the user performing the action
+	ActorEmail string            `json:"actorEmail"`
+	SourceIP   string            `json:"sourceIP"`
+	Changes    map[string]Change `json:"changes"`
+}
+
+// NewEntry builds an audit entry for the given request
+// The actor is taken from the cognito claims and the source IP
+// from the API Gateway request context
+func NewEntry(req events.APIGatewayProxyRequest, mac string, action string) Entry {
+	actorID, actorEmail := Actor(req)
+	return Entry{
+		MAC:        mac,
+		Timestamp:  time.Now().UTC().Format(TimestampFormat),
+		Action:     action,
+		ActorID:    actorID,
+		ActorEmail: actorEmail,
+		SourceIP:   req.RequestContext.Identity.SourceIP,
+		Changes:    map[string]Change{},
+	}
+}
+
+// Actor returns the sub and email claims of the user making the request
+// Either is empty when the authorizer did not provide it
+func Actor(req events.APIGatewayProxyRequest) (string, string) {
+	var sub, email string
+	if claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		sub, _ = claims["sub"].(string)
+		email, _ = claims["email"].(string)
+	}
+	return sub, email
+}
+
+// Diff compares two dynamo items and returns the string and number attributes that differ
+// A nil before item means the device was just created
+func Diff(before map[string]*dynamodb.AttributeValue, after map[string]*dynamodb.AttributeValue) map[string]Change {
+	changes := map[string]Change{}
+
+	for name, value := range after {
+		beforeValue := scalarValue(before[name])
+		afterValue := scalarValue(value)
+		if beforeValue != afterValue {
+			changes[name] = Change{Before: beforeValue, After: afterValue}
+		}
+	}
+
+	for name, value := range before {
+		if _, ok := after[name]; ok {
+			continue
+		}
+		if beforeValue := scalarValue(value); beforeValue != "" {
+			changes[name] = Change{Before: beforeValue}
+		}
+	}
+
+	return changes
+}
+
+// Record writes the audit entry to dynamo
+// Callers should not treat the mutation as done when this fails, an
+// unaudited change is not visible to the owner
+func Record(dynamoService *dynamodb.DynamoDB, entry Entry) error {
+	changes := make(map[string]*dynamodb.AttributeValue)
+	for name, change := range entry.Changes {
+		changes[name] = &dynamodb.AttributeValue{
+			M: map[string]*dynamodb.AttributeValue{
+				"Before": StringAttribute(change.Before),
+				"After":  StringAttribute(change.After),
+			},
+		}
+	}
+
+	dynamoInputItem := map[string]*dynamodb.AttributeValue{
+		"MAC":        {S: aws.String(entry.MAC)},
+		"Timestamp":  {S: aws.String(SortKey(entry.Timestamp))},
+		"Action":     {S: aws.String(entry.Action)},
+		"ActorID":    StringAttribute(entry.ActorID),
+		"ActorEmail": StringAttribute(entry.ActorEmail),
+		"SourceIP":   StringAttribute(entry.SourceIP),
+		"Changes":    {M: changes},
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(#T)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#T": aws.String("Timestamp"),
+		},
+		TableName: aws.String(TableName),
+		Item:      dynamoInputItem,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error writing audit entry for %s: %v", entry.MAC, err)
+	}
+
+	return nil
+}
+
+// List returns the audit entries of a device, newest first
+// startKey continues a previous listing, the returned string is the
+// range key to continue from or empty if there are no more entries
+func List(dynamoService *dynamodb.DynamoDB, mac string, limit int64, startKey string) ([]Entry, string, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		KeyConditionExpression: aws.String("MAC = :m"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":m": {S: aws.String(mac)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(limit),
+	}
+
+	if startKey != "" {
+		dynamoInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"MAC":       {S: aws.String(mac)},
+			"Timestamp": {S: aws.String(startKey)},
+		}
+	}
+
+	dynamoResponse, err := dynamoService.Query(&dynamoInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("error querying audit entries for %s: %v", mac, err)
+	}
+
+	entries := make([]Entry, 0, len(dynamoResponse.Items))
+	for _, item := range dynamoResponse.Items {
+		entries = append(entries, entryFromItem(item))
+	}
+
+	var next string
+	if dynamoResponse.LastEvaluatedKey != nil {
+		next = stringValue(dynamoResponse.LastEvaluatedKey["Timestamp"])
+	}
+
+	return entries, next, nil
+}
+
+// ParseLimit parses the limit query string parameter
+// falling back to the default when it is missing or out of range
+func ParseLimit(raw string) int64 {
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		return 25
+	}
+	return limit
+}
+
+func entryFromItem(item map[string]*dynamodb.AttributeValue) Entry {
+	entry := Entry{
+		MAC:        stringValue(item["MAC"]),
+		Timestamp:  keyTimestamp(stringValue(item["Timestamp"])),
+		Action:     stringValue(item["Action"]),
+		ActorID:    stringValue(item["ActorID"]),
+		ActorEmail: stringValue(item["ActorEmail"]),
+		SourceIP:   stringValue(item["SourceIP"]),
+		Changes:    map[string]Change{},
+	}
+
+	if item["Changes"] != nil {
+		for name, change := range item["Changes"].M {
+			if change == nil {
+				continue
+			}
+			entry.Changes[name] = Change{
+				Before: stringValue(change.M["Before"]),
+				After:  stringValue(change.M["After"]),
+			}
+		}
+	}
+
+	return entry
+}
+
+// SortKey returns the range key of an entry written at the timestamp
+// The suffix comes after the fixed width timestamp, so entries still
+// sort in the order they were written
+func SortKey(timestamp string) string {
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		panic(err)
+	}
+	return timestamp + KeySeparator + hex.EncodeToString(suffix)
+}
+
+// keyTimestamp returns the timestamp a range key built by SortKey starts with
+func keyTimestamp(key string) string {
+	return strings.SplitN(key, KeySeparator, 2)[0]
+}
+
+// StringAttribute returns a dynamo string attribute
+// dynamo does not accept empty strings so those are stored as NULL
+func StringAttribute(value string) *dynamodb.AttributeValue {
+	if value == "" {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	}
+	return &dynamodb.AttributeValue{S: aws.String(value)}
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+// scalarValue returns the string or number held by the attribute
+func scalarValue(value *dynamodb.AttributeValue) string {
+	if value == nil {
+		return ""
+	}
+	if value.N != nil {
+		return *value.N
+	}
+	return stringValue(value)
+}
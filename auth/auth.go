@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"strings"
+	"time"
+)
+
+// AccessTokenHeader carries the cognito access token on endpoints that
+// act on the cognito user itself, X-HERMES-CLOUD-TOKEN carries the id token
+const AccessTokenHeader = "X-HERMES-CLOUD-ACCESS-TOKEN"
+
+// Tokens are the cognito tokens handed out on login
+type Tokens struct {
+	IDToken     string `json:"idToken"`
+	AccessToken string `json:"accessToken"`
+	// RefreshToken is only returned on login, refreshing keeps the old one
+	RefreshToken string `json:"refreshToken,omitempty"`
+	TokenType    string `json:"tokenType"`
+	// ExpiresIn is the lifetime of the id and access tokens in seconds
+	ExpiresIn int64  `json:"expiresIn"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// NewTokens converts the authentication result of cognito
+func NewTokens(result *cognitoidentityprovider.AuthenticationResultType, now time.Time) Tokens {
+	expiresIn := aws.Int64Value(result.ExpiresIn)
+	return Tokens{
+		IDToken:      aws.StringValue(result.IdToken),
+		AccessToken:  aws.StringValue(result.AccessToken),
+		RefreshToken: aws.StringValue(result.RefreshToken),
+		TokenType:    aws.StringValue(result.TokenType),
+		ExpiresIn:    expiresIn,
+		ExpiresAt:    now.Add(time.Duration(expiresIn) * time.Second).UTC().Format(time.RFC3339),
+	}
+}
+
+// ErrorStatus maps a cognito error to the status code and message
+// returned to the user
+// Cognito messages are never passed on, unknown errors are a 500
+func ErrorStatus(err error) (int, string) {
+	switch Code(err) {
+	// Unknown users get the same answer as wrong passwords
+	// so that registered emails can not be discovered
+	case cognitoidentityprovider.ErrCodeNotAuthorizedException,
+		cognitoidentityprovider.ErrCodeUserNotFoundException:
+		return 401, "Incorrect email or password"
+	case cognitoidentityprovider.ErrCodeUserNotConfirmedException:
+		return 403, "User is not confirmed"
+	case cognitoidentityprovider.ErrCodePasswordResetRequiredException:
+		return 403, "Password reset required"
+	case cognitoidentityprovider.ErrCodeTooManyRequestsException,
+		cognitoidentityprovider.ErrCodeLimitExceededException:
+		return 429, "Too many requests, try again later"
+	case cognitoidentityprovider.ErrCodeInvalidPasswordException:
+		return 400, "Password does not meet the password policy"
+	case cognitoidentityprovider.ErrCodeCodeMismatchException:
+		return 400, "Invalid code provided"
+	case cognitoidentityprovider.ErrCodeExpiredCodeException:
+		return 400, "Code has expired"
+	case cognitoidentityprovider.ErrCodeInvalidParameterException:
+		return 400, "Invalid request"
+	}
+
+	return 500, "Something went wrong"
+}
+
+// Code returns the cognito error code of err, empty if it has none
+func Code(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// AccessToken returns the access token of the request headers
+// Clients do not agree on the case of header names, so neither does this
+func AccessToken(headers map[string]string) string {
+	for name, value := range headers {
+		if strings.EqualFold(name, AccessTokenHeader) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"net/url"
+)
+
+// MFAIssuer is the name authenticator apps show for the account
+const MFAIssuer = "Hermes Cloud"
+
+// OTPAuthURI returns the otpauth URI of a TOTP secret, authenticator
+// apps add the account by scanning it as a QR code
+func OTPAuthURI(account string, secret string) string {
+	// Spaces are escaped as %20 throughout, some apps show a + literally
+	label := url.PathEscape(MFAIssuer + ":" + account)
+	return "otpauth://totp/" + label + "?secret=" + url.QueryEscape(secret) + "&issuer=" + url.PathEscape(MFAIssuer)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// passwordSymbols are the characters cognito counts as symbols
+const passwordSymbols = "^$*.[]{}()?\"!@#%&/\\,><':;|_~`=+-"
+
+// PasswordPolicy is the password policy of the user pool
+type PasswordPolicy struct {
+	MinimumLength    int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireNumbers   bool
+	RequireSymbols   bool
+}
+
+// LoadPasswordPolicy reads the password policy of the user pool
+func LoadPasswordPolicy(cognitoService *cognitoidentityprovider.CognitoIdentityProvider, userPoolID string) (PasswordPolicy, error) {
+	cognitoInput := cognitoidentityprovider.DescribeUserPoolInput{
+		UserPoolId: aws.String(userPoolID),
+	}
+
+	cognitoResponse, err := cognitoService.DescribeUserPool(&cognitoInput)
+	if err != nil {
+		return PasswordPolicy{}, fmt.Errorf("error describing user pool %s: %v", userPoolID, err)
+	}
+
+	userPool := cognitoResponse.UserPool
+	if userPool == nil || userPool.Policies == nil || userPool.Policies.PasswordPolicy == nil {
+		return PasswordPolicy{}, nil
+	}
+
+	policy := userPool.Policies.PasswordPolicy
+	return PasswordPolicy{
+		MinimumLength:    int(aws.Int64Value(policy.MinimumLength)),
+		RequireLowercase: aws.BoolValue(policy.RequireLowercase),
+		RequireUppercase: aws.BoolValue(policy.RequireUppercase),
+		RequireNumbers:   aws.BoolValue(policy.RequireNumbers),
+		RequireSymbols:   aws.BoolValue(policy.RequireSymbols),
+	}, nil
+}
+
+// Validate returns an error describing the first requirement the
+// password does not meet
+// The message is meant to be returned to the user as is
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinimumLength {
+		return fmt.Errorf("Password needs to be at least %d characters long", p.MinimumLength)
+	}
+	// Cognito rejects leading and trailing whitespace
+	if strings.TrimSpace(password) != password {
+		return errors.New("Password can not start or end with whitespace")
+	}
+
+	var lower, upper, number, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			number = true
+		case strings.ContainsRune(passwordSymbols, r):
+			symbol = true
+		}
+	}
+
+	if p.RequireLowercase && !lower {
+		return errors.New("Password needs to contain a lowercase letter")
+	}
+	if p.RequireUppercase && !upper {
+		return errors.New("Password needs to contain an uppercase letter")
+	}
+	if p.RequireNumbers && !number {
+		return errors.New("Password needs to contain a number")
+	}
+	if p.RequireSymbols && !symbol {
+		return errors.New("Password needs to contain a symbol")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitTable hash key is RateKey
+const RateLimitTable = "rate_limits"
+
+// Limit allows Requests per Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Allow counts a request against key and reports whether it is
+// within the limit
+// Requests are counted in fixed windows, the counter of a window
+// expires with the dynamo TTL once the window is over
+func Allow(dynamoService *dynamodb.DynamoDB, key string, limit Limit, now time.Time) (bool, error) {
+	windowStart := now.Truncate(limit.Window)
+
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(RateLimitTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RateKey": {S: aws.String(fmt.Sprintf("%s#%d", key, windowStart.Unix()))},
+		},
+		UpdateExpression:    aws.String("ADD RequestCount :one SET ExpiresAt = :e"),
+		ConditionExpression: aws.String("attribute_not_exists(RequestCount) OR RequestCount < :l"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":l":   {N: aws.String(strconv.Itoa(limit.Requests))},
+			":e":   {N: aws.String(strconv.FormatInt(windowStart.Add(limit.Window).Unix(), 10))},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		return false, fmt.Errorf("error counting request %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// NormalizeEmail makes differently typed versions of the same
+// email address count as one
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
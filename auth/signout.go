@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/idtoken"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// UsersTable hash key is userID, the cognito sub of the user
+const UsersTable = "users"
+
+// RecordSignOut stores when the user signed out everywhere
+func RecordSignOut(dynamoService *dynamodb.DynamoDB, userID string, at time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("SET SignedOutAt = :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {N: aws.String(strconv.FormatInt(at.Unix(), 10))},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error recording sign out of %s: %v", userID, err)
+	}
+	return nil
+}
+
+// SignedOutAt returns when the user last signed out everywhere,
+// the zero time if they never did
+func SignedOutAt(dynamoService *dynamodb.DynamoDB, userID string) (time.Time, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		ProjectionExpression: aws.String("SignedOutAt"),
+		ConsistentRead:       aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error looking up sign out of %s: %v", userID, err)
+	}
+
+	signedOutAt := dynamoResponse.Item["SignedOutAt"]
+	if signedOutAt == nil || signedOutAt.N == nil {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(*signedOutAt.N, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing sign out of %s: %v", userID, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// SignedOut reports whether the session an ID token belongs to was
+// started before the user last signed out everywhere
+// Cognito revokes refresh tokens on global sign out, this makes sure
+// sessions from before it are not refreshed even if it did not
+func SignedOut(dynamoService *dynamodb.DynamoDB, idToken string) (bool, error) {
+	claims, err := idtoken.Claims(idToken)
+	if err != nil {
+		return false, err
+	}
+	userID, _ := claims["sub"].(string)
+	authTime, _ := claims["auth_time"].(float64)
+
+	signedOutAt, err := SignedOutAt(dynamoService, userID)
+	if err != nil {
+		return false, err
+	}
+	return !signedOutAt.IsZero() && int64(authTime) < signedOutAt.Unix(), nil
+}
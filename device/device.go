@@ -0,0 +1,203 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/geo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TableName hash key is MAC
+const TableName = "devices"
+
+// OwnerGeohashIndex is the global secondary index of the devices table
+// Hash key is Owner, range key is Geohash
+// It is sparse, devices registered without a location are not in it
+const OwnerGeohashIndex = "OwnerGeohashIndex"
+
+// OwnerIndex is the global secondary index of the devices table holding
+// every device of an owner
+// Hash key is Owner, range key is MAC
+const OwnerIndex = "OwnerIndex"
+
+// Statuses a device can have
+const (
+	StatusOffline     = "offline"
+	StatusOnline      = "online"
+	StatusMaintenance = "maintenance"
+)
+
+// ErrNotFound is returned when a device does not exist
+var ErrNotFound = errors.New("not found")
+
+var macPattern = regexp.MustCompile("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$")
+
+// Update is a partial update of a device
+// Only the fields that are set are changed
+type Update struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Location replaces the whole stored location when provided
+	Location *geo.Location `json:"location"`
+}
+
+// ValidMAC reports whether mac is a MAC address
+func ValidMAC(mac string) bool {
+	return macPattern.MatchString(mac)
+}
+
+// ValidStatus reports whether a device can have the status
+func ValidStatus(status string) bool {
+	return status == StatusOffline || status == StatusOnline || status == StatusMaintenance
+}
+
+// Validate returns an error describing the first invalid field
+// The message is meant to be returned to the user as is
+func (u Update) Validate() error {
+	// Needs to be 50 characters or less
+	if utf8.RuneCountInString(u.Name) > 50 {
+		return errors.New("Provided name too long")
+	}
+
+	if u.Status != "" && !ValidStatus(u.Status) {
+		return errors.New("status can only have value 'offline', 'online' or 'maintenance'")
+	}
+
+	if u.Location != nil {
+		err := u.Location.Validate()
+		if err != nil {
+			return fmt.Errorf("Invalid location provided: %s", err)
+		}
+	}
+
+	if u.Name == "" && u.Status == "" && u.Location == nil {
+		return errors.New("request JSON needs at least one of name, status or location")
+	}
+
+	return nil
+}
+
+// Get returns the stored item of the device or ErrNotFound
+func Get(dynamoService *dynamodb.DynamoDB, mac string) (map[string]*dynamodb.AttributeValue, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up device %s: %v", mac, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return nil, ErrNotFound
+	}
+	return dynamoResponse.Item, nil
+}
+
+// Apply writes the update to the device and returns the item as it
+// was before and as it is after the update
+// The update has to be validated and the device has to exist
+func Apply(dynamoService *dynamodb.DynamoDB, mac string, update Update) (map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error) {
+	// Only the attributes present in the update are set
+	updatedAttributes := make(map[string]*dynamodb.AttributeValue)
+	var removedAttributes []string
+
+	if update.Name != "" {
+		updatedAttributes["Name"] = &dynamodb.AttributeValue{S: aws.String(update.Name)}
+	}
+
+	if update.Status != "" {
+		updatedAttributes["Status"] = &dynamodb.AttributeValue{S: aws.String(update.Status)}
+	}
+
+	// A new location replaces the old one entirely, so optional
+	// fields missing from the update are removed
+	if update.Location != nil {
+		updatedAttributes["Latitude"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatFloat(*update.Location.Latitude, 'f', -1, 64)),
+		}
+		updatedAttributes["Longitude"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatFloat(*update.Location.Longitude, 'f', -1, 64)),
+		}
+		updatedAttributes["Geohash"] = &dynamodb.AttributeValue{
+			S: aws.String(geo.Encode(*update.Location.Latitude, *update.Location.Longitude, geo.Precision)),
+		}
+		if update.Location.Altitude != nil {
+			updatedAttributes["Altitude"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatFloat(*update.Location.Altitude, 'f', -1, 64)),
+			}
+		} else {
+			removedAttributes = append(removedAttributes, "Altitude")
+		}
+		if update.Location.Accuracy != nil {
+			updatedAttributes["Accuracy"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatFloat(*update.Location.Accuracy, 'f', -1, 64)),
+			}
+		} else {
+			removedAttributes = append(removedAttributes, "Accuracy")
+		}
+	}
+
+	expressionAttributeNames := make(map[string]*string)
+	expressionAttributeValues := make(map[string]*dynamodb.AttributeValue)
+
+	var setClauses []string
+	for attributeName, attributeValue := range updatedAttributes {
+		placeholder := strconv.Itoa(len(expressionAttributeNames))
+		setClauses = append(setClauses, fmt.Sprintf("#a%s = :v%s", placeholder, placeholder))
+		expressionAttributeNames["#a"+placeholder] = aws.String(attributeName)
+		expressionAttributeValues[":v"+placeholder] = attributeValue
+	}
+
+	dynamoUpdateExpressionString := "SET " + strings.Join(setClauses, ", ")
+
+	var removeClauses []string
+	for _, attributeName := range removedAttributes {
+		placeholder := strconv.Itoa(len(expressionAttributeNames))
+		removeClauses = append(removeClauses, "#a"+placeholder)
+		expressionAttributeNames["#a"+placeholder] = aws.String(attributeName)
+	}
+
+	if len(removeClauses) > 0 {
+		dynamoUpdateExpressionString += " REMOVE " + strings.Join(removeClauses, ", ")
+	}
+
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		UpdateExpression:          aws.String(dynamoUpdateExpressionString),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String("ALL_OLD"),
+	}
+
+	dynamoResponse, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error updating device %s: %v", mac, err)
+	}
+
+	// ALL_OLD gives us the item as it was right before this update
+	// so apply the update to a copy of it to get the after state
+	updatedItem := make(map[string]*dynamodb.AttributeValue)
+	for attributeName, attributeValue := range dynamoResponse.Attributes {
+		updatedItem[attributeName] = attributeValue
+	}
+	for attributeName, attributeValue := range updatedAttributes {
+		updatedItem[attributeName] = attributeValue
+	}
+	for _, attributeName := range removedAttributes {
+		delete(updatedItem, attributeName)
+	}
+
+	return dynamoResponse.Attributes, updatedItem, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/geo"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"strconv"
+)
+
+// MaxRadius is the largest radius in meters accepted by a radius query
+const MaxRadius = 1000000
+
+// Device describes a device returned by an area query
+type Device struct {
+	MAC   string `json:"mac"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// OwnerEmail is the email of the owner when the device was registered
+	OwnerEmail string       `json:"ownerEmail,omitempty"`
+	Status     string       `json:"status"`
+	Location   geo.Location `json:"location"`
+	// Distance in meters from the center of a radius query
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// Response defines the response structure to this device area request
+type Response struct {
+	Message string   `json:"Response"`
+	Error   string   `json:"Error"`
+	Devices []Device `json:"Devices,omitempty"`
+}
+
+// FindDevicesInArea is the lambda function handler
+// it returns the caller's devices within a bounding box or radius
+func FindDevicesInArea(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	params := req.QueryStringParameters
+
+	var box geo.BoundingBox
+	var center *[2]float64
+	var radius float64
+
+	if params["radius"] != "" {
+		latitude, latErr := strconv.ParseFloat(params["lat"], 64)
+		longitude, lonErr := strconv.ParseFloat(params["lon"], 64)
+		parsedRadius, radiusErr := strconv.ParseFloat(params["radius"], 64)
+		if latErr != nil || lonErr != nil || radiusErr != nil {
+			resp := Response{
+				Message: "radius queries need numeric lat, lon and radius query parameters",
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+
+		centerLocation := geo.Location{Latitude: &latitude, Longitude: &longitude}
+		err := centerLocation.Validate()
+		if err == nil && (parsedRadius <= 0 || parsedRadius > MaxRadius) {
+			err = fmt.Errorf("radius must be greater than 0 and at most %d meters", MaxRadius)
+		}
+		if err != nil {
+			resp := Response{
+				Message: fmt.Sprintf("Invalid area provided: %s", err),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+
+		center = &[2]float64{latitude, longitude}
+		radius = parsedRadius
+		box = geo.RadiusBoundingBox(latitude, longitude, radius)
+	} else {
+		var parseErrors [4]error
+		box.MinLatitude, parseErrors[0] = strconv.ParseFloat(params["minLat"], 64)
+		box.MinLongitude, parseErrors[1] = strconv.ParseFloat(params["minLon"], 64)
+		box.MaxLatitude, parseErrors[2] = strconv.ParseFloat(params["maxLat"], 64)
+		box.MaxLongitude, parseErrors[3] = strconv.ParseFloat(params["maxLon"], 64)
+		for _, parseError := range parseErrors {
+			if parseError != nil {
+				resp := Response{
+					Message: "bounding box queries need numeric minLat, minLon, maxLat and maxLon query parameters",
+					Error:   "Invalid Request",
+				}
+				marshalledResponse, err := json.Marshal(resp)
+				if err != nil {
+					log.Println("Error marshalling response:", resp)
+					panic(err)
+				}
+				return events.APIGatewayProxyResponse{
+					Body:       string(marshalledResponse),
+					StatusCode: 400,
+				}, nil
+			}
+		}
+
+		err := box.Validate()
+		if err != nil {
+			resp := Response{
+				Message: fmt.Sprintf("Invalid area provided: %s", err),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Only the caller's own devices are searched, or those of the
+	// organization in the organization query parameter
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	owner := subFromToken
+	if params["organization"] != "" {
+		owner = org.Owner(params["organization"])
+		authorized, err := org.Authorize(dynamoService, subFromToken, owner, org.RoleViewer)
+		if err != nil {
+			log.Println("Error looking up membership (dynamo)", err)
+			resp := Response{
+				Message: "Error looking up devices",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+		if !authorized {
+			resp := Response{
+				Message: "Not authorized to perform this action",
+				Error:   "Not authorized",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+		}
+	}
+
+	devices := []Device{}
+
+	// Each covering cell is a prefix of the stored geohashes,
+	// the index narrows the candidates and the exact check below
+	// drops those outside the requested area
+	for _, cell := range geo.CoveringCells(box) {
+		dynamoInput := dynamodb.QueryInput{
+			TableName:              aws.String(device.TableName),
+			IndexName:              aws.String(device.OwnerGeohashIndex),
+			KeyConditionExpression: aws.String("#O = :o AND begins_with(Geohash, :g)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#O": aws.String("Owner"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":o": {S: aws.String(owner)},
+				":g": {S: aws.String(cell)},
+			},
+		}
+
+		err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				device, ok := deviceFromItem(item)
+				if !ok {
+					continue
+				}
+				latitude := *device.Location.Latitude
+				longitude := *device.Location.Longitude
+				if center != nil {
+					distance := geo.Distance(center[0], center[1], latitude, longitude)
+					if distance > radius {
+						continue
+					}
+					device.Distance = &distance
+				} else if !box.Contains(latitude, longitude) {
+					continue
+				}
+				devices = append(devices, device)
+			}
+			return true
+		})
+		if err != nil {
+			log.Println("Error querying devices by area (dynamo)", err)
+			resp := Response{
+				Message: "Error looking up devices",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Found %d devices", len(devices)),
+		Devices: devices,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+// deviceFromItem converts a dynamo item to a Device
+// items without a complete location are reported as not ok
+func deviceFromItem(item map[string]*dynamodb.AttributeValue) (Device, bool) {
+	var device Device
+
+	if item["MAC"] != nil && item["MAC"].S != nil {
+		device.MAC = *item["MAC"].S
+	}
+	if item["Name"] != nil && item["Name"].S != nil {
+		device.Name = *item["Name"].S
+	}
+	if item["Owner"] != nil && item["Owner"].S != nil {
+		device.Owner = *item["Owner"].S
+	}
+	if item["OwnerEmail"] != nil && item["OwnerEmail"].S != nil {
+		device.OwnerEmail = *item["OwnerEmail"].S
+	}
+	if item["Status"] != nil && item["Status"].S != nil {
+		device.Status = *item["Status"].S
+	}
+
+	device.Location.Latitude = numberValue(item["Latitude"])
+	device.Location.Longitude = numberValue(item["Longitude"])
+	device.Location.Altitude = numberValue(item["Altitude"])
+	device.Location.Accuracy = numberValue(item["Accuracy"])
+
+	return device, device.Location.Latitude != nil && device.Location.Longitude != nil
+}
+
+func numberValue(value *dynamodb.AttributeValue) *float64 {
+	if value == nil || value.N == nil {
+		return nil
+	}
+	number, err := strconv.ParseFloat(*value.N, 64)
+	if err != nil {
+		return nil
+	}
+	return &number
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.DeviceRead, FindDevicesInArea))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// Response defines the response structure to this device audit request
+type Response struct {
+	Message string        `json:"Response"`
+	Error   string        `json:"Error"`
+	Entries []audit.Entry `json:"Entries,omitempty"`
+	// Next is passed back as the next query string parameter
+	// to fetch the following page of entries
+	Next string `json:"Next,omitempty"`
+}
+
+// GetDeviceAudit is the lambda function handler
+// it returns the audit log of a device to its owner
+func GetDeviceAudit(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	mac, err := url.PathUnescape(req.PathParameters["mac"])
+	if err != nil || mac == "" {
+		resp := Response{
+			Message: "mac missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the MAC
+	validMAC, err := regexp.MatchString("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$", mac)
+	if validMAC == false {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid MAC Address Provided: %s", mac),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	// This is the cognito sub provided by the JWT
+	// in the request
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	dynamoGetInput := dynamodb.GetItemInput{
+		TableName: aws.String("devices"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoGetInput)
+	if err != nil {
+		log.Println("Error looking up device (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up MAC",
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	if len(dynamoResponse.Item) == 0 {
+		resp := Response{
+			Message: fmt.Sprintf("MAC not found: %s", mac),
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 404,
+		}, nil
+	}
+
+	// Viewers of the organization owning the device may read its
+	// audit log too
+	authorized, err := org.Authorize(dynamoService, subFromToken, aws.StringValue(dynamoResponse.Item["Owner"].S), org.RoleViewer)
+	if err != nil {
+		log.Println("Error looking up membership (dynamo)", err)
+		resp := Response{
+			Message: "Error retrieving audit log",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !authorized {
+		resp := Response{
+			Message: "Not authorized to perform this action",
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	limit := audit.ParseLimit(req.QueryStringParameters["limit"])
+
+	entries, next, err := audit.List(dynamoService, mac, limit, req.QueryStringParameters["next"])
+	if err != nil {
+		log.Println("Error listing audit entries (dynamo)", err)
+		resp := Response{
+			Message: "Error retrieving audit log",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully retrieved audit log for device %s", mac),
+		Entries: entries,
+		Next:    next,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.DeviceRead, GetDeviceAudit))
+}
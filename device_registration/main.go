@@ -4,6 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/geo"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,6 +17,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"unicode/utf8"
 )
 
@@ -20,9 +26,14 @@ type DeviceRegEvent struct {
 	Name string `json:"name"`
 	MAC  string `json:"mac"`
 	// The Owner is the user who owns the device
-	// See the users table
-	// This value should be an email address
+	// It is optional and has to be the email address or the cognito sub
+	// of the logged in user, the device is always owned by them
 	Owner string `json:"owner"`
+	// Organization is optional, when set the device is owned by that
+	// organization instead, which needs the user to be an operator of it
+	Organization string `json:"organization"`
+	// Location is optional
+	Location *geo.Location `json:"location"`
 }
 
 // Response defines the response structure to this device registration request
@@ -85,9 +96,12 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
-	emailFromToken := typedAuthorizer["email"]
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	emailFromToken, _ := typedAuthorizer["email"].(string)
 
-	if emailFromToken != evt.Owner {
+	// Emails are compared case insensitively, the stored owner is
+	// the sub, which stays the same when the user changes their email
+	if evt.Owner != "" && evt.Owner != subFromToken && auth.NormalizeEmail(evt.Owner) != auth.NormalizeEmail(emailFromToken) {
 		resp := Response{
 			Message: "Not authorized to perform this action",
 			Error:   "Not authorized",
@@ -132,22 +146,6 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
-	if evt.Owner == "" {
-		resp := Response{
-			Message: "owner missing from request JSON",
-			Error:   "Invalid Request",
-		}
-		marshalledResponse, err := json.Marshal(resp)
-		if err != nil {
-			log.Println("Error marshalling response:", resp)
-			panic(err)
-		}
-		return events.APIGatewayProxyResponse{
-			Body:       string(marshalledResponse),
-			StatusCode: 400,
-		}, nil
-	}
-
 	// Validate the MAC
 	validMAC, err := regexp.MatchString("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$", evt.MAC)
 	if validMAC == false {
@@ -184,10 +182,61 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
+	// Validate the Location
+	if evt.Location != nil {
+		err = evt.Location.Validate()
+		if err != nil {
+			resp := Response{
+				Message: fmt.Sprintf("Invalid location provided: %s", err),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+	}
+
 	sess := session.Must(session.NewSession())
 
 	dynamoService := dynamodb.New(sess)
 
+	owner := subFromToken
+	if evt.Organization != "" {
+		owner = org.Owner(evt.Organization)
+		authorized, err := org.Authorize(dynamoService, subFromToken, owner, org.RoleOperator)
+		if err != nil {
+			log.Println("Error looking up membership (dynamo)", err)
+			resp := Response{
+				Message: "Error creating device",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+		if !authorized {
+			resp := Response{
+				Message: "Not authorized to perform this action",
+				Error:   "Not authorized",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+		}
+	}
+
 	// TODO: dynamodbattribute.MarshalMap does not work!? Need to figure out why, for now, we'll make the
 	// required structs manually
 
@@ -200,7 +249,12 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 	}
 
 	ownerAttributeValue := dynamodb.AttributeValue{
-		S: &evt.Owner,
+		S: &owner,
+	}
+
+	// OwnerEmail is only displayed, it is not used to authorize anything
+	ownerEmailAttributeValue := dynamodb.AttributeValue{
+		S: &emailFromToken,
 	}
 
 	statusAttributeValue := dynamodb.AttributeValue{
@@ -214,8 +268,33 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 	dynamoInputItem["MAC"] = &macAttributeValue
 	dynamoInputItem["Name"] = &nameAttributeValue
 	dynamoInputItem["Owner"] = &ownerAttributeValue
+	dynamoInputItem["OwnerEmail"] = &ownerEmailAttributeValue
 	dynamoInputItem["Status"] = &statusAttributeValue
 
+	// The geohash is indexed together with the owner
+	// so area queries don't need to scan the table
+	if evt.Location != nil {
+		dynamoInputItem["Latitude"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatFloat(*evt.Location.Latitude, 'f', -1, 64)),
+		}
+		dynamoInputItem["Longitude"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatFloat(*evt.Location.Longitude, 'f', -1, 64)),
+		}
+		dynamoInputItem["Geohash"] = &dynamodb.AttributeValue{
+			S: aws.String(geo.Encode(*evt.Location.Latitude, *evt.Location.Longitude, geo.Precision)),
+		}
+		if evt.Location.Altitude != nil {
+			dynamoInputItem["Altitude"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatFloat(*evt.Location.Altitude, 'f', -1, 64)),
+			}
+		}
+		if evt.Location.Accuracy != nil {
+			dynamoInputItem["Accuracy"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatFloat(*evt.Location.Accuracy, 'f', -1, 64)),
+			}
+		}
+	}
+
 	dynamoInput := dynamodb.PutItemInput{
 		ConditionExpression: aws.String("attribute_not_exists(MAC)"),
 		TableName:           aws.String("devices"),
@@ -236,6 +315,25 @@ func CreateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
 	}
 
+	// The change is applied at this point, but it is not reported as done
+	// without its audit entry
+	auditEntry := audit.NewEntry(req, evt.MAC, audit.ActionCreateDevice)
+	auditEntry.Changes = audit.Diff(nil, dynamoInputItem)
+	err = audit.Record(dynamoService, auditEntry)
+	if err != nil {
+		resp := Response{
+			Message: fmt.Sprintf("Device %s was registered, but recording the change in its audit log failed", evt.MAC),
+			Error:   "Something went wrong",
+		}
+		log.Println("Error recording audit entry:", err)
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
 	resp := Response{
 		Message: fmt.Sprintf("Successfully registered device %s", evt.MAC),
 	}
@@ -259,5 +357,5 @@ func main() {
 		log.Fatal("AWS_REGION not set")
 	}
 
-	lambda.Start(CreateDevice)
+	lambda.Start(rbac.Require(rbac.DeviceWrite, CreateDevice))
 }
@@ -4,6 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/geo"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,7 +18,6 @@ import (
 	"log"
 	"os"
 	"regexp"
-	"unicode/utf8"
 )
 
 // DeviceUpdateEvent defines the request structure of this device update request
@@ -21,14 +25,19 @@ type DeviceUpdateEvent struct {
 	MAC    string `json:"mac"`
 	Name   string `json:"name"`
 	Status string `json:"status"`
+	// Location replaces the whole stored location when provided
+	Location *geo.Location `json:"location"`
 }
 
 // Device describes the schema of the returned dynamo object
 type Device struct {
-	MAC    string `json:"mac"`
-	Name   string `json:"name"`
-	Owner  string `json:"owner"`
-	Status string `json:"status"`
+	MAC   string `json:"mac"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// OwnerEmail is the email of the owner when the device was registered
+	OwnerEmail string        `json:"ownerEmail,omitempty"`
+	Status     string        `json:"status"`
+	Location   *geo.Location `json:"location,omitempty"`
 }
 
 // Response defines the response structure to this device update request
@@ -92,44 +101,28 @@ func UpdateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
-	// Validate the Device Name
-	// Needs to be 50 characters or less
-	if evt.Name != "" {
-		if utf8.RuneCountInString(evt.Name) > 50 {
-			resp := Response{
-				Message: "Provided name too long",
-				Error:   "Invalid Request",
-			}
-			marshalledResponse, err := json.Marshal(resp)
-			if err != nil {
-				log.Println("Error marshalling response:", resp)
-				panic(err)
-			}
-			return events.APIGatewayProxyResponse{
-				Body:       string(marshalledResponse),
-				StatusCode: 400,
-			}, nil
-		}
+	update := device.Update{
+		Name:     evt.Name,
+		Status:   evt.Status,
+		Location: evt.Location,
 	}
 
-	// Validate the status
-	// Needs to be 'offline' or 'online'
-	if evt.Status != "" {
-		if evt.Status != "offline" && evt.Status != "online" {
-			resp := Response{
-				Message: "status can only have value 'offline' or 'online'",
-				Error:   "Invalid Request",
-			}
-			marshalledResponse, err := json.Marshal(resp)
-			if err != nil {
-				log.Println("Error marshalling response:", resp)
-				panic(err)
-			}
-			return events.APIGatewayProxyResponse{
-				Body:       string(marshalledResponse),
-				StatusCode: 400,
-			}, nil
+	// Validate the Device Name, Status and Location
+	err = update.Validate()
+	if err != nil {
+		resp := Response{
+			Message: err.Error(),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
 		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
 	}
 
 	authorizer := req.RequestContext.Authorizer
@@ -165,9 +158,9 @@ func UpdateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
-	// This is the email address provided by the JWT
+	// This is the cognito sub provided by the JWT
 	// in the request
-	emailFromToken := typedAuthorizer["email"]
+	subFromToken, _ := typedAuthorizer["sub"].(string)
 
 	sess := session.Must(session.NewSession())
 
@@ -246,13 +239,23 @@ func UpdateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		}, nil
 	}
 
-	// This is the email address associated with the MAC in DynamoDB
-	emailFromDynamo := dynamoResponse.Item["Owner"].S
-
-	// This means the person sending the request
-	// Does not have a token matching the device owner
-	// As reported by dynamo
-	if emailFromToken != *emailFromDynamo {
+	// The device is owned by the user or by an organization the
+	// user operates devices of
+	authorized, err := org.Authorize(dynamoService, subFromToken, aws.StringValue(dynamoResponse.Item["Owner"].S), org.RoleOperator)
+	if err != nil {
+		log.Println("Error looking up membership (dynamo)", err)
+		resp := Response{
+			Message: "Error updating device",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !authorized {
 		resp := Response{
 			Message: "Not authorized to perform this action",
 			Error:   "Not authorized",
@@ -265,67 +268,32 @@ func UpdateDevice(ctx context.Context, req events.APIGatewayProxyRequest) (event
 		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
 	}
 
-	var dynamoUpdateExpressionString string
-
-	dynamoUpdateExpressionString = "SET"
-
-	var expressionAttributeNames map[string]*string
-	var expressionAttributeValues map[string]*dynamodb.AttributeValue
-
-	Name := "Name"
-	Status := "Status"
-
-	statusAttributeValue := dynamodb.AttributeValue{
-		S: &evt.Status,
-	}
-
-	nameAttributeValue := dynamodb.AttributeValue{
-		S: &evt.Name,
-	}
-
-	if evt.Name != "" && evt.Status != "" {
-		dynamoUpdateExpressionString = "SET #N = :n, #S = :s"
-		expressionAttributeNames = map[string]*string{
-			"#N": &Name,
-			"#S": &Status,
-		}
-		expressionAttributeValues = map[string]*dynamodb.AttributeValue{
-			":n": &nameAttributeValue,
-			":s": &statusAttributeValue,
-		}
-	} else if evt.Name != "" {
-		dynamoUpdateExpressionString = "SET #N = :n"
-		expressionAttributeNames = map[string]*string{
-			"#N": &Name,
-		}
-		expressionAttributeValues = map[string]*dynamodb.AttributeValue{
-			":n": &nameAttributeValue,
-		}
-	} else if evt.Status != "" {
-		dynamoUpdateExpressionString = "SET #S = :s"
-		expressionAttributeNames = map[string]*string{
-			"#S": &Status,
+	previousItem, updatedItem, err := device.Apply(dynamoService, evt.MAC, update)
+	if err != nil {
+		resp := Response{
+			Message: "Error updating device",
+			Error:   "Something went wrong",
 		}
-		expressionAttributeValues = map[string]*dynamodb.AttributeValue{
-			":s": &statusAttributeValue,
+		log.Println("DynamoDB Error", err)
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
 		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
 	}
 
-	dynamoInput := dynamodb.UpdateItemInput{
-		TableName:                 aws.String("devices"),
-		Key:                       dynamoKey,
-		UpdateExpression:          aws.String(dynamoUpdateExpressionString),
-		ExpressionAttributeNames:  expressionAttributeNames,
-		ExpressionAttributeValues: expressionAttributeValues,
-	}
-
-	_, err = dynamoService.UpdateItem(&dynamoInput)
+	// The change is applied at this point, but it is not reported as done
+	// without its audit entry
+	auditEntry := audit.NewEntry(req, evt.MAC, audit.ActionUpdateDevice)
+	auditEntry.Changes = audit.Diff(previousItem, updatedItem)
+	err = audit.Record(dynamoService, auditEntry)
 	if err != nil {
 		resp := Response{
-			Message: "Error updating device",
+			Message: fmt.Sprintf("Device %s was updated, but recording the change in its audit log failed", evt.MAC),
 			Error:   "Something went wrong",
 		}
-		log.Println("DynamoDB Error", err)
+		log.Println("Error recording audit entry:", err)
 		marshalledResponse, err := json.Marshal(resp)
 		if err != nil {
 			log.Println("Error marshalling response:", resp)
@@ -357,5 +325,5 @@ func main() {
 		log.Fatal("AWS_REGION not set")
 	}
 
-	lambda.Start(UpdateDevice)
+	lambda.Start(rbac.Require(rbac.DeviceWrite, UpdateDevice))
 }
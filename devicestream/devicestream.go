@@ -0,0 +1,34 @@
+package devicestream
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Item converts a stream image of the devices table to the
+// attribute values used by the dynamo client
+// Only string and number attributes are kept, which covers
+// every attribute of a device
+func Item(image map[string]events.DynamoDBAttributeValue) map[string]*dynamodb.AttributeValue {
+	item := make(map[string]*dynamodb.AttributeValue)
+
+	for name, value := range image {
+		switch value.DataType() {
+		case events.DataTypeString:
+			item[name] = &dynamodb.AttributeValue{S: aws.String(value.String())}
+		case events.DataTypeNumber:
+			item[name] = &dynamodb.AttributeValue{N: aws.String(value.Number())}
+		}
+	}
+
+	return item
+}
+
+// String returns the string attribute of the item or an empty string
+func String(item map[string]*dynamodb.AttributeValue, name string) string {
+	if item[name] == nil || item[name].S == nil {
+		return ""
+	}
+	return *item[name].S
+}
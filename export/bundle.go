@@ -0,0 +1,264 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/ingest"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/schedule"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"time"
+)
+
+// redactedAttributes are the attributes of stored items left out of the bundle
+// The webhook secret would let anyone holding the bundle sign deliveries
+var redactedAttributes = map[string][]string{
+	webhook.SubscriptionsTable: {"Secret"},
+}
+
+// bundle is a zip archive holding a JSON lines file per table
+type bundle struct {
+	buffer bytes.Buffer
+	writer *zip.Writer
+}
+
+func newBundle() *bundle {
+	b := &bundle{}
+	b.writer = zip.NewWriter(&b.buffer)
+	return b
+}
+
+// add writes one JSON object per item to the file
+func (b *bundle) add(name string, table string, items []map[string]*dynamodb.AttributeValue) error {
+	file, err := b.writer.Create(name)
+	if err != nil {
+		return fmt.Errorf("error adding %s to export: %v", name, err)
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, item := range items {
+		object := plainItem(item)
+		for _, attributeName := range redactedAttributes[table] {
+			delete(object, attributeName)
+		}
+		err = encoder.Encode(object)
+		if err != nil {
+			return fmt.Errorf("error encoding %s item: %v", table, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *bundle) close() ([]byte, error) {
+	err := b.writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error closing export: %v", err)
+	}
+	return b.buffer.Bytes(), nil
+}
+
+// Build returns the zip archive of everything stored about the user
+// Every table is a JSON lines file of its items, attributes as stored
+func Build(dynamoService *dynamodb.DynamoDB, userID string) ([]byte, error) {
+	b := newBundle()
+
+	users, err := queryItems(dynamoService, auth.UsersTable, "", "userID", userID)
+	if err != nil {
+		return nil, err
+	}
+	err = b.add("user.jsonl", auth.UsersTable, users)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := queryItems(dynamoService, device.TableName, device.OwnerIndex, "Owner", userID)
+	if err != nil {
+		return nil, err
+	}
+	err = b.add("devices.jsonl", device.TableName, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status history of a device is in its audit log and telemetry
+	var auditEntries, telemetry []map[string]*dynamodb.AttributeValue
+	for _, item := range devices {
+		mac := stringValue(item["MAC"])
+
+		entries, err := queryItems(dynamoService, audit.TableName, "", "MAC", mac)
+		if err != nil {
+			return nil, err
+		}
+		auditEntries = append(auditEntries, entries...)
+
+		metrics, err := queryItems(dynamoService, ingest.TelemetryTable, "", "MAC", mac)
+		if err != nil {
+			return nil, err
+		}
+		telemetry = append(telemetry, metrics...)
+	}
+	err = b.add("device_audit.jsonl", audit.TableName, auditEntries)
+	if err != nil {
+		return nil, err
+	}
+	err = b.add("device_telemetry.jsonl", ingest.TelemetryTable, telemetry)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := queryItems(dynamoService, org.MembersTable, org.UserIndex, "userID", userID)
+	if err != nil {
+		return nil, err
+	}
+	err = b.add("organization_memberships.jsonl", org.MembersTable, memberships)
+	if err != nil {
+		return nil, err
+	}
+
+	ownedTables := []struct {
+		name  string
+		table string
+	}{
+		{"webhooks.jsonl", webhook.SubscriptionsTable},
+		{"notification_channels.jsonl", notify.ChannelsTable},
+		{"notification_subscriptions.jsonl", notify.SubscriptionsTable},
+		{"alert_rules.jsonl", alert.RulesTable},
+		{"alert_history.jsonl", alert.HistoryTable},
+		{"schedules.jsonl", schedule.SchedulesTable},
+	}
+	for _, owned := range ownedTables {
+		items, err := queryItems(dynamoService, owned.table, "", "Owner", userID)
+		if err != nil {
+			return nil, err
+		}
+		err = b.add(owned.name, owned.table, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.close()
+}
+
+// Generate builds the pending export and uploads it to the bucket
+// A failed export is recorded as such, the user can request a new one
+func Generate(dynamoService *dynamodb.DynamoDB, s3Service *s3.S3, bucket string, export Export) error {
+	err := Claim(dynamoService, export)
+	if err == ErrConflict {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	archive, err := Build(dynamoService, export.UserID)
+	if err == nil {
+		objectKey := "exports/" + export.UserID + "/" + export.ExportID + ".zip"
+		_, err = s3Service.PutObject(&s3.PutObjectInput{
+			Bucket:             aws.String(bucket),
+			Key:                aws.String(objectKey),
+			Body:               bytes.NewReader(archive),
+			ContentType:        aws.String("application/zip"),
+			ContentDisposition: aws.String("attachment; filename=\"hermes-export-" + export.ExportID + ".zip\""),
+		})
+		if err != nil {
+			err = fmt.Errorf("error uploading export %s: %v", export.ExportID, err)
+		} else {
+			export.ObjectKey = objectKey
+			export.Size = int64(len(archive))
+		}
+	}
+
+	export.Status = StatusCompleted
+	if err != nil {
+		log.Printf("Error generating export %s: %v\n", export.ExportID, err)
+		export.Status = StatusFailed
+		export.Error = err.Error()
+	}
+	export.CompletedAt = time.Now().UTC().Format(TimestampFormat)
+
+	return Finish(dynamoService, export)
+}
+
+// queryItems returns every item whose hash key is hashValue
+func queryItems(dynamoService *dynamodb.DynamoDB, table string, index string, hashKey string, hashValue string) ([]map[string]*dynamodb.AttributeValue, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("#h = :h"),
+		ExpressionAttributeNames: map[string]*string{
+			"#h": aws.String(hashKey),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":h": {S: aws.String(hashValue)},
+		},
+	}
+	if index != "" {
+		dynamoInput.IndexName = aws.String(index)
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s of %s: %v", table, hashValue, err)
+	}
+
+	return items, nil
+}
+
+// plainItem converts the item to values encoding/json can marshal
+func plainItem(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	object := make(map[string]interface{})
+	for attributeName, attributeValue := range item {
+		object[attributeName] = plainValue(attributeValue)
+	}
+	return object
+}
+
+func plainValue(value *dynamodb.AttributeValue) interface{} {
+	switch {
+	case value == nil:
+		return nil
+	case value.S != nil:
+		return *value.S
+	case value.N != nil:
+		return json.Number(*value.N)
+	case value.BOOL != nil:
+		return *value.BOOL
+	case value.B != nil:
+		return value.B
+	case value.SS != nil:
+		return aws.StringValueSlice(value.SS)
+	case value.NS != nil:
+		numbers := make([]json.Number, len(value.NS))
+		for i, number := range value.NS {
+			numbers[i] = json.Number(aws.StringValue(number))
+		}
+		return numbers
+	case value.BS != nil:
+		return value.BS
+	case value.M != nil:
+		return plainItem(value.M)
+	case value.L != nil:
+		list := make([]interface{}, len(value.L))
+		for i, element := range value.L {
+			list[i] = plainValue(element)
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strconv"
+	"time"
+)
+
+// ExportsTable hash key is userID, range key is ExportID
+const ExportsTable = "data_exports"
+
+// TimestampFormat sorts lexicographically in chronological order
+const TimestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Statuses an export can have
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Retention is how long an export can be downloaded, the bucket
+// needs a lifecycle rule expiring the objects after the same time
+const Retention = 7 * 24 * time.Hour
+
+// DownloadExpiry is how long a download URL is valid
+const DownloadExpiry = 15 * time.Minute
+
+// ErrNotFound is returned when an export does not exist
+var ErrNotFound = errors.New("export not found")
+
+// ErrConflict is returned when the export is no longer pending
+var ErrConflict = errors.New("export is not pending")
+
+// Export is a request of a user for a copy of their data
+type Export struct {
+	UserID      string `json:"-"`
+	ExportID    string `json:"id"`
+	Status      string `json:"status"`
+	RequestedAt string `json:"requestedAt"`
+	CompletedAt string `json:"completedAt,omitempty"`
+	// ExpiresAt is when the export can no longer be downloaded
+	ExpiresAt   string `json:"expiresAt"`
+	ObjectKey   string `json:"-"`
+	Size        int64  `json:"size,omitempty"`
+	Error       string `json:"-"`
+	DownloadURL string `json:"downloadURL,omitempty"`
+}
+
+// New returns a pending export of the data of the user
+func New(userID string, exportID string, now time.Time) Export {
+	return Export{
+		UserID:      userID,
+		ExportID:    now.UTC().Format(TimestampFormat) + "-" + exportID,
+		Status:      StatusPending,
+		RequestedAt: now.UTC().Format(TimestampFormat),
+		ExpiresAt:   now.Add(Retention).UTC().Format(TimestampFormat),
+	}
+}
+
+// generationTimeout is the longest an export can take, an export still
+// unfinished after it was lost by a failed invocation
+const generationTimeout = 15 * time.Minute
+
+// InProgress reports whether the export is still being generated
+func (e Export) InProgress(now time.Time) bool {
+	if e.Status != StatusPending && e.Status != StatusRunning {
+		return false
+	}
+	return e.RequestedAt > now.Add(-generationTimeout).UTC().Format(TimestampFormat)
+}
+
+// PutExport stores a new export, its stream record starts generating it
+func PutExport(dynamoService *dynamodb.DynamoDB, export Export) error {
+	expiresAt, err := time.Parse(TimestampFormat, export.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error parsing expiry of export %s: %v", export.ExportID, err)
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(ExportsTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"userID":      {S: aws.String(export.UserID)},
+			"ExportID":    {S: aws.String(export.ExportID)},
+			"Status":      {S: aws.String(export.Status)},
+			"RequestedAt": {S: aws.String(export.RequestedAt)},
+			// TTL attribute, so the export disappears along with its object
+			"ExpiresAt": {N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(ExportID)"),
+	}
+
+	_, err = dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing export %s: %v", export.ExportID, err)
+	}
+	return nil
+}
+
+// GetExport returns the export of the user
+func GetExport(dynamoService *dynamodb.DynamoDB, userID string, exportID string) (Export, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(ExportsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID":   {S: aws.String(userID)},
+			"ExportID": {S: aws.String(exportID)},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Export{}, fmt.Errorf("error getting export %s: %v", exportID, err)
+	}
+	if dynamoResponse.Item == nil {
+		return Export{}, ErrNotFound
+	}
+
+	return exportFromItem(dynamoResponse.Item), nil
+}
+
+// ListExports returns the exports of the user, newest first
+func ListExports(dynamoService *dynamodb.DynamoDB, userID string) ([]Export, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(ExportsTable),
+		KeyConditionExpression: aws.String("userID = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {S: aws.String(userID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	exports := []Export{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			exports = append(exports, exportFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing exports of %s: %v", userID, err)
+	}
+
+	return exports, nil
+}
+
+// Claim marks the pending export as running, it returns ErrConflict
+// when a concurrent invocation claimed it first
+func Claim(dynamoService *dynamodb.DynamoDB, export Export) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ExportsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID":   {S: aws.String(export.UserID)},
+			"ExportID": {S: aws.String(export.ExportID)},
+		},
+		UpdateExpression:    aws.String("SET #S = :r"),
+		ConditionExpression: aws.String("#S = :p"),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {S: aws.String(StatusRunning)},
+			":p": {S: aws.String(StatusPending)},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("error claiming export %s: %v", export.ExportID, err)
+	}
+	return nil
+}
+
+// Finish records the outcome of a running export
+func Finish(dynamoService *dynamodb.DynamoDB, export Export) error {
+	updateExpression := "SET #S = :s, CompletedAt = :c"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":s": {S: aws.String(export.Status)},
+		":c": {S: aws.String(export.CompletedAt)},
+	}
+	if export.ObjectKey != "" {
+		updateExpression += ", ObjectKey = :k, #Z = :z"
+		expressionAttributeValues[":k"] = &dynamodb.AttributeValue{S: aws.String(export.ObjectKey)}
+		expressionAttributeValues[":z"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(export.Size, 10))}
+	}
+	if export.Error != "" {
+		updateExpression += ", #E = :e"
+		expressionAttributeValues[":e"] = &dynamodb.AttributeValue{S: aws.String(export.Error)}
+	}
+
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ExportsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID":   {S: aws.String(export.UserID)},
+			"ExportID": {S: aws.String(export.ExportID)},
+		},
+		UpdateExpression: aws.String(updateExpression),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("Status"),
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+	}
+	if export.ObjectKey != "" {
+		dynamoInput.ExpressionAttributeNames["#Z"] = aws.String("Size")
+	}
+	if export.Error != "" {
+		dynamoInput.ExpressionAttributeNames["#E"] = aws.String("Error")
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error finishing export %s: %v", export.ExportID, err)
+	}
+	return nil
+}
+
+// DownloadURL returns a presigned URL of the object of the completed export
+func DownloadURL(s3Service *s3.S3, bucket string, export Export) (string, error) {
+	s3Request, _ := s3Service.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(export.ObjectKey),
+	})
+
+	url, err := s3Request.Presign(DownloadExpiry)
+	if err != nil {
+		return "", fmt.Errorf("error presigning export %s: %v", export.ExportID, err)
+	}
+	return url, nil
+}
+
+// DeleteExports deletes every export of the user along with their objects
+func DeleteExports(dynamoService *dynamodb.DynamoDB, s3Service *s3.S3, bucket string, userID string) error {
+	exports, err := ListExports(dynamoService, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.ObjectKey != "" {
+			_, err = s3Service.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(export.ObjectKey),
+			})
+			if err != nil {
+				return fmt.Errorf("error deleting object of export %s: %v", export.ExportID, err)
+			}
+		}
+
+		dynamoInput := dynamodb.DeleteItemInput{
+			TableName: aws.String(ExportsTable),
+			Key: map[string]*dynamodb.AttributeValue{
+				"userID":   {S: aws.String(userID)},
+				"ExportID": {S: aws.String(export.ExportID)},
+			},
+		}
+		_, err = dynamoService.DeleteItem(&dynamoInput)
+		if err != nil {
+			return fmt.Errorf("error deleting export %s: %v", export.ExportID, err)
+		}
+	}
+
+	return nil
+}
+
+func exportFromItem(item map[string]*dynamodb.AttributeValue) Export {
+	export := Export{
+		UserID:      stringValue(item["userID"]),
+		ExportID:    stringValue(item["ExportID"]),
+		Status:      stringValue(item["Status"]),
+		RequestedAt: stringValue(item["RequestedAt"]),
+		CompletedAt: stringValue(item["CompletedAt"]),
+		ObjectKey:   stringValue(item["ObjectKey"]),
+		Error:       stringValue(item["Error"]),
+	}
+	if item["Size"] != nil && item["Size"].N != nil {
+		export.Size, _ = strconv.ParseInt(*item["Size"].N, 10, 64)
+	}
+	if item["ExpiresAt"] != nil && item["ExpiresAt"].N != nil {
+		expiresAt, _ := strconv.ParseInt(*item["ExpiresAt"].N, 10, 64)
+		export.ExpiresAt = time.Unix(expiresAt, 0).UTC().Format(TimestampFormat)
+	}
+	return export
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/export"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// exportLimit keeps users from generating exports over and over,
+// each one reads everything they own
+var exportLimit = auth.Limit{Requests: 3, Window: 24 * time.Hour}
+
+// Response defines the response structure to this export request
+type Response struct {
+	Message string         `json:"Response"`
+	Error   string         `json:"Error"`
+	Export  *export.Export `json:"Export,omitempty"`
+}
+
+// CreateExport is the lambda function handler
+// it requests an export of everything stored about the logged in user
+// The export is generated in the background by export_generate
+func CreateExport(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	now := time.Now()
+
+	exports, err := export.ListExports(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing exports (dynamo):", err)
+		resp := Response{
+			Message: "Error requesting export",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+	for _, existing := range exports {
+		if existing.InProgress(now) {
+			resp := Response{
+				Message: "An export is already being generated",
+				Error:   "Conflict",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 409,
+			}, nil
+		}
+	}
+
+	allowed, err := auth.Allow(dynamoService, "export:"+subFromToken, exportLimit, now)
+	if err != nil {
+		log.Println("Error checking rate limit (dynamo):", err)
+		resp := Response{
+			Message: "Error requesting export",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+	if !allowed {
+		resp := Response{
+			Message: "Too many exports requested, try again tomorrow",
+			Error:   "Rate limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 429,
+		}, nil
+	}
+
+	newExport := export.New(subFromToken, webhook.NewID()[:8], now)
+
+	err = export.PutExport(dynamoService, newExport)
+	if err != nil {
+		log.Println("Error storing export (dynamo):", err)
+		resp := Response{
+			Message: "Error requesting export",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	resp := Response{
+		Message: "Export requested, it will be ready to download shortly",
+		Export:  &newExport,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 202}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, CreateExport))
+}
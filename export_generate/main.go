@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/devicestream"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/export"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"os"
+)
+
+// GenerateExports is the lambda function handler
+// it consumes the data_exports table stream and generates every
+// requested export
+func GenerateExports(ctx context.Context, evt events.DynamoDBEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	s3Service := s3.New(sess)
+
+	for _, record := range evt.Records {
+		if record.EventName != "INSERT" {
+			continue
+		}
+
+		newItem := devicestream.Item(record.Change.NewImage)
+		userID := devicestream.String(newItem, "userID")
+		exportID := devicestream.String(newItem, "ExportID")
+
+		pendingExport, err := export.GetExport(dynamoService, userID, exportID)
+		if err == export.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			// Returning the error makes lambda retry the batch
+			return fmt.Errorf("error getting export %s: %v", exportID, err)
+		}
+
+		err = export.Generate(dynamoService, s3Service, os.Getenv("EXPORT_BUCKET"), pendingExport)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("EXPORT_BUCKET") == "" {
+		log.Fatal("EXPORT_BUCKET not set")
+	}
+
+	lambda.Start(GenerateExports)
+}
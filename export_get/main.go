@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/export"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"os"
+	"time"
+)
+
+// Response defines the response structure to this export request
+type Response struct {
+	Message string         `json:"Response"`
+	Error   string         `json:"Error"`
+	Export  *export.Export `json:"Export,omitempty"`
+}
+
+// GetExport is the lambda function handler
+// it returns the export of the logged in user, with a download URL once it completed
+func GetExport(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	exportID := req.PathParameters["id"]
+	if exportID == "" {
+		resp := Response{
+			Message: "id missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	foundExport, err := export.GetExport(dynamoService, subFromToken, exportID)
+	if err == export.ErrNotFound {
+		resp := Response{
+			Message: "Export not found",
+			Error:   "Not Found",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		log.Println("Error getting export (dynamo):", err)
+		resp := Response{
+			Message: "Error getting export",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	// The TTL deletes expired exports within a few days, not right away
+	if foundExport.ExpiresAt < time.Now().UTC().Format(export.TimestampFormat) {
+		resp := Response{
+			Message: "Export expired, request a new one",
+			Error:   "Export Expired",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 410,
+		}, nil
+	}
+
+	if foundExport.Status == export.StatusCompleted {
+		s3Service := s3.New(sess)
+		foundExport.DownloadURL, err = export.DownloadURL(s3Service, os.Getenv("EXPORT_BUCKET"), foundExport)
+		if err != nil {
+			log.Println("Error creating download URL (s3):", err)
+			resp := Response{
+				Message: "Error getting export",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 500,
+			}, nil
+		}
+	}
+
+	resp := Response{
+		Message: "Found export",
+		Export:  &foundExport,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("EXPORT_BUCKET") == "" {
+		log.Fatal("EXPORT_BUCKET not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, GetExport))
+}
@@ -0,0 +1,217 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Precision is the geohash length stored with each device
+// 9 characters is roughly a 5m x 5m cell
+const Precision = 9
+
+// MaxCells is the most geohash cells an area query will be split into
+const MaxCells = 16
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Location describes where a device is
+// Latitude and Longitude are required, Altitude (meters) and
+// Accuracy (meters) are optional
+type Location struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+	Accuracy  *float64 `json:"accuracy,omitempty"`
+}
+
+// BoundingBox is an area delimited by two corners
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// Validate checks that the location is complete and in range
+func (l Location) Validate() error {
+	if l.Latitude == nil || l.Longitude == nil {
+		return errors.New("location requires both latitude and longitude")
+	}
+	if *l.Latitude < -90 || *l.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if *l.Longitude < -180 || *l.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if l.Altitude != nil && (*l.Altitude < -11000 || *l.Altitude > 100000) {
+		return errors.New("altitude must be between -11000 and 100000 meters")
+	}
+	if l.Accuracy != nil && (*l.Accuracy < 0 || *l.Accuracy > 100000) {
+		return errors.New("accuracy must be between 0 and 100000 meters")
+	}
+	return nil
+}
+
+// Validate checks that the bounding box is in range
+// Boxes crossing the antimeridian are not supported
+func (b BoundingBox) Validate() error {
+	if b.MinLatitude < -90 || b.MaxLatitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if b.MinLongitude < -180 || b.MaxLongitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if b.MinLatitude > b.MaxLatitude {
+		return errors.New("minLat must not be greater than maxLat")
+	}
+	if b.MinLongitude > b.MaxLongitude {
+		return errors.New("minLon must not be greater than maxLon")
+	}
+	return nil
+}
+
+// Contains reports whether the point lies within the bounding box
+func (b BoundingBox) Contains(latitude float64, longitude float64) bool {
+	return latitude >= b.MinLatitude && latitude <= b.MaxLatitude &&
+		longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+// Encode returns the geohash of the point with the given precision
+func Encode(latitude float64, longitude float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	even := true
+	bit := 0
+	ch := 0
+
+	for hash.Len() < precision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if longitude >= mid {
+				ch = ch<<1 | 1
+				lonRange[0] = mid
+			} else {
+				ch = ch << 1
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if latitude >= mid {
+				ch = ch<<1 | 1
+				latRange[0] = mid
+			} else {
+				ch = ch << 1
+				latRange[1] = mid
+			}
+		}
+		even = !even
+
+		bit++
+		if bit == 5 {
+			hash.WriteByte(base32[ch])
+			bit = 0
+			ch = 0
+		}
+	}
+
+	return hash.String()
+}
+
+// cellSize returns the height and width in degrees of a geohash cell
+func cellSize(precision int) (float64, float64) {
+	bits := uint(precision * 5)
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / float64(uint64(1)<<latBits), 360 / float64(uint64(1)<<lonBits)
+}
+
+// CoveringCells returns the geohash prefixes that together cover the
+// bounding box, using the longest prefix that needs at most MaxCells cells
+func CoveringCells(b BoundingBox) []string {
+	precision := Precision
+	for precision > 1 {
+		minLat, minLon, rows, columns := grid(b, precision)
+		if rows*columns <= MaxCells {
+			return cells(precision, minLat, minLon, rows, columns)
+		}
+		precision--
+	}
+	minLat, minLon, rows, columns := grid(b, precision)
+	return cells(precision, minLat, minLon, rows, columns)
+}
+
+// grid snaps the bounding box to the cell grid of the given precision
+// and returns its south west corner and dimensions in cells
+func grid(b BoundingBox, precision int) (float64, float64, int, int) {
+	height, width := cellSize(precision)
+
+	minLat := math.Floor((b.MinLatitude+90)/height)*height - 90
+	minLon := math.Floor((b.MinLongitude+180)/width)*width - 180
+
+	rows := int(math.Floor((b.MaxLatitude-minLat)/height)) + 1
+	columns := int(math.Floor((b.MaxLongitude-minLon)/width)) + 1
+
+	return minLat, minLon, rows, columns
+}
+
+func cells(precision int, minLat float64, minLon float64, rows int, columns int) []string {
+	height, width := cellSize(precision)
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for row := 0; row < rows; row++ {
+		latitude := math.Min(minLat+(float64(row)+0.5)*height, 90)
+		for column := 0; column < columns; column++ {
+			longitude := math.Min(minLon+(float64(column)+0.5)*width, 180)
+			hash := Encode(latitude, longitude, precision)
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+
+	return hashes
+}
+
+// RadiusBoundingBox returns the bounding box enclosing the circle of
+// radius meters around the point
+func RadiusBoundingBox(latitude float64, longitude float64, radius float64) BoundingBox {
+	latDelta := radius / earthRadius * 180 / math.Pi
+
+	box := BoundingBox{
+		MinLatitude:  math.Max(latitude-latDelta, -90),
+		MaxLatitude:  math.Min(latitude+latDelta, 90),
+		MinLongitude: -180,
+		MaxLongitude: 180,
+	}
+
+	// Near the poles the circle spans every longitude
+	cosLat := math.Cos(latitude * math.Pi / 180)
+	if box.MinLatitude > -90 && box.MaxLatitude < 90 && cosLat > 0 {
+		lonDelta := latDelta / cosLat
+		box.MinLongitude = math.Max(longitude-lonDelta, -180)
+		box.MaxLongitude = math.Min(longitude+lonDelta, 180)
+	}
+
+	return box
+}
+
+// Distance returns the great circle distance in meters between two points
+func Distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaPhi := (lat2 - lat1) * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
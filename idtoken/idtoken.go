@@ -0,0 +1,186 @@
+package idtoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired,
+// not signed by the user pool or not meant for the app client
+var ErrInvalidToken = errors.New("invalid token")
+
+// Verifier checks cognito ID tokens outside of an API Gateway authorizer,
+// where there is no authorizer to do it for us, like WebSocket connections
+type Verifier struct {
+	Region     string
+	UserPoolID string
+	ClientID   string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// refetchInterval limits how often unknown key ids make the
+// verifier fetch the key set again
+const refetchInterval = 5 * time.Minute
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// Issuer returns the issuer of tokens of the user pool
+func (v *Verifier) Issuer() string {
+	return fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", v.Region, v.UserPoolID)
+}
+
+// Verify checks the signature and the claims of the token and returns
+// the claims, in the same shape API Gateway hands them to handlers
+func (v *Verifier) Verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var h header
+	if json.Unmarshal(rawHeader, &h) != nil || h.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := v.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := decodeClaims(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Unix() >= int64(exp) {
+		return nil, ErrInvalidToken
+	}
+	if claims["iss"] != v.Issuer() || claims["aud"] != v.ClientID || claims["token_use"] != "id" {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// Claims returns the claims of the token without verifying it
+// Only use it on tokens that come straight from cognito
+func Claims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	return decodeClaims(parts[1])
+}
+
+func decodeClaims(part string) (map[string]interface{}, error) {
+	rawClaims, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims map[string]interface{}
+	if json.Unmarshal(rawClaims, &claims) != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// key returns the signing key with the given id, fetching the key set
+// of the user pool on first use and again when cognito rotates keys
+func (v *Verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < refetchInterval {
+		return nil, ErrInvalidToken
+	}
+
+	req, err := http.NewRequest("GET", v.Issuer()+"/.well-known/jwks.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching user pool keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching user pool keys: status %d", resp.StatusCode)
+	}
+
+	var set jwks
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding user pool keys: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
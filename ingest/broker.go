@@ -0,0 +1,72 @@
+package ingest
+
+import (
+	"strings"
+	"sync"
+)
+
+// TopicFilter matches every topic devices publish to
+const TopicFilter = "hermes/+/+"
+
+// MemoryBroker delivers published messages to the matching subscribers
+// right away, it stands in for AWS IoT when testing locally
+//
+//	broker := &ingest.MemoryBroker{}
+//	broker.Subscribe(ingest.TopicFilter, handler.Handle)
+//	err := broker.Publish("hermes/AA:BB:CC:DD:EE:FF/status", []byte(`{"status": "online"}`))
+type MemoryBroker struct {
+	mu            sync.Mutex
+	subscriptions []subscription
+}
+
+type subscription struct {
+	filter  string
+	handler func(Message) error
+}
+
+// Subscribe calls handler for every message published to a topic
+// matching the filter, which can use the + and # wildcards
+func (b *MemoryBroker) Subscribe(filter string, handler func(Message) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, subscription{filter: filter, handler: handler})
+}
+
+// Publish delivers the payload to every matching subscriber and
+// returns the first error a subscriber returned
+func (b *MemoryBroker) Publish(topic string, payload []byte) error {
+	b.mu.Lock()
+	subscriptions := append([]subscription{}, b.subscriptions...)
+	b.mu.Unlock()
+
+	var firstErr error
+	for _, s := range subscriptions {
+		if !TopicMatches(s.filter, topic) {
+			continue
+		}
+		err := s.handler(Message{Topic: topic, Payload: payload})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// TopicMatches reports whether the topic matches the MQTT topic filter
+func TopicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
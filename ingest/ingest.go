@@ -0,0 +1,167 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Topics devices publish to, the MAC is the second level
+const (
+	TopicPrefix = "hermes/"
+	// KindStatus messages look like {"status": "online"}
+	KindStatus = "status"
+	// KindTelemetry messages look like {"metrics": {"temperature": 21.5}}
+	KindTelemetry = "telemetry"
+)
+
+// MaxMetrics is the number of metrics a telemetry message can carry
+const MaxMetrics = 50
+
+// maxClockSkew is how far in the future a device timestamp may be
+const maxClockSkew = 5 * time.Minute
+
+// InvalidMessageError is returned for malformed messages
+// Those messages are dropped, retrying them cannot succeed
+type InvalidMessageError struct {
+	Reason string
+}
+
+func (e *InvalidMessageError) Error() string {
+	return "invalid message: " + e.Reason
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &InvalidMessageError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// ErrNotApplied is returned when the device is not registered or the
+// message is older than the last status applied to the device
+var ErrNotApplied = errors.New("update not applied")
+
+var metricPattern = regexp.MustCompile("^[A-Za-z0-9_.-]{1,64}$")
+
+// Message is a message published by a device
+// The IoT rule delivers the payload base64 encoded, which is how
+// encoding/json decodes into a byte slice
+type Message struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// StatusReport is the payload of a status message
+type StatusReport struct {
+	Status string `json:"status"`
+	// Timestamp is RFC 3339, the time the message is handled when empty
+	Timestamp string `json:"timestamp"`
+}
+
+// TelemetryReport is the payload of a telemetry message
+type TelemetryReport struct {
+	Metrics   map[string]float64 `json:"metrics"`
+	Timestamp string             `json:"timestamp"`
+}
+
+// ParseTopic returns the MAC and kind of a hermes/{mac}/{kind} topic
+func ParseTopic(topic string) (string, string, error) {
+	if !strings.HasPrefix(topic, TopicPrefix) {
+		return "", "", invalid("unexpected topic %s", topic)
+	}
+	levels := strings.Split(strings.TrimPrefix(topic, TopicPrefix), "/")
+	if len(levels) != 2 {
+		return "", "", invalid("unexpected topic %s", topic)
+	}
+
+	mac, kind := levels[0], levels[1]
+	if !device.ValidMAC(mac) {
+		return "", "", invalid("invalid MAC address %s", mac)
+	}
+	if kind != KindStatus && kind != KindTelemetry {
+		return "", "", invalid("unexpected topic %s", topic)
+	}
+
+	return mac, kind, nil
+}
+
+// Handler validates device messages and applies them to the store
+type Handler struct {
+	Store Store
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// Handle applies a single message
+// An InvalidMessageError or ErrNotApplied means the message should
+// be dropped, any other error is worth retrying
+func (h *Handler) Handle(message Message) error {
+	mac, kind, err := ParseTopic(message.Topic)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case KindStatus:
+		var report StatusReport
+		err = json.Unmarshal(message.Payload, &report)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		if !device.ValidStatus(report.Status) {
+			return invalid("status can only have value 'offline', 'online' or 'maintenance'")
+		}
+		at, err := h.timestamp(report.Timestamp)
+		if err != nil {
+			return err
+		}
+		return h.Store.UpdateStatus(mac, report.Status, at)
+
+	default:
+		var report TelemetryReport
+		err = json.Unmarshal(message.Payload, &report)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		if len(report.Metrics) == 0 || len(report.Metrics) > MaxMetrics {
+			return invalid("a telemetry message needs between 1 and %d metrics", MaxMetrics)
+		}
+		for name := range report.Metrics {
+			if !metricPattern.MatchString(name) {
+				return invalid("invalid metric name %s", name)
+			}
+		}
+		at, err := h.timestamp(report.Timestamp)
+		if err != nil {
+			return err
+		}
+		return h.Store.PutTelemetry(mac, report.Metrics, at)
+	}
+}
+
+// IsDropped reports whether err means the message should be dropped
+func IsDropped(err error) bool {
+	_, ok := err.(*InvalidMessageError)
+	return ok || err == ErrNotApplied
+}
+
+func (h *Handler) timestamp(raw string) (time.Time, error) {
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+	if raw == "" {
+		return now.UTC(), nil
+	}
+
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, invalid("timestamp is not RFC 3339")
+	}
+	if at.After(now.Add(maxClockSkew)) {
+		return time.Time{}, invalid("timestamp is in the future")
+	}
+	return at.UTC(), nil
+}
@@ -0,0 +1,167 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+const testMAC = "AA:BB:CC:DD:EE:FF"
+
+var testNow = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newBroker returns a local broker delivering to a handler backed by
+// a memory store that knows testMAC
+func newBroker() (*MemoryBroker, *MemoryStore) {
+	store := NewMemoryStore(testMAC)
+	handler := &Handler{
+		Store: store,
+		Now:   func() time.Time { return testNow },
+	}
+	broker := &MemoryBroker{}
+	broker.Subscribe(TopicFilter, handler.Handle)
+	return broker, store
+}
+
+func isInvalid(err error) bool {
+	_, ok := err.(*InvalidMessageError)
+	return ok
+}
+
+func TestParseTopic(t *testing.T) {
+	mac, kind, err := ParseTopic("hermes/" + testMAC + "/status")
+	if err != nil || mac != testMAC || kind != KindStatus {
+		t.Errorf("ParseTopic = %s, %s, %v, want %s, %s, nil", mac, kind, err, testMAC, KindStatus)
+	}
+
+	mac, kind, err = ParseTopic("hermes/" + testMAC + "/telemetry")
+	if err != nil || mac != testMAC || kind != KindTelemetry {
+		t.Errorf("ParseTopic = %s, %s, %v, want %s, %s, nil", mac, kind, err, testMAC, KindTelemetry)
+	}
+
+	invalidTopics := []string{
+		"",
+		"devices/" + testMAC + "/status",
+		"hermes/" + testMAC,
+		"hermes/" + testMAC + "/status/extra",
+		"hermes/" + testMAC + "/reboot",
+		"hermes/not-a-mac/status",
+		"hermes/AA:BB:CC:DD:EE/status",
+	}
+	for _, topic := range invalidTopics {
+		_, _, err := ParseTopic(topic)
+		if !isInvalid(err) {
+			t.Errorf("ParseTopic(%q) = %v, want an InvalidMessageError", topic, err)
+		}
+	}
+}
+
+func TestPublishStatus(t *testing.T) {
+	broker, store := newBroker()
+
+	err := broker.Publish("hermes/"+testMAC+"/status", []byte(`{"status": "online"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Status(testMAC) != "online" {
+		t.Errorf("status = %s, want online", store.Status(testMAC))
+	}
+}
+
+func TestPublishInvalidStatus(t *testing.T) {
+	broker, store := newBroker()
+
+	err := broker.Publish("hermes/"+testMAC+"/status", []byte(`{"status": "rebooting"}`))
+	if !isInvalid(err) || !IsDropped(err) {
+		t.Errorf("Publish = %v, want a dropped InvalidMessageError", err)
+	}
+	if store.Status(testMAC) != "offline" {
+		t.Errorf("status = %s, want offline", store.Status(testMAC))
+	}
+}
+
+func TestPublishUnknownDevice(t *testing.T) {
+	broker, _ := newBroker()
+
+	err := broker.Publish("hermes/11:22:33:44:55:66/status", []byte(`{"status": "online"}`))
+	if err != ErrNotApplied || !IsDropped(err) {
+		t.Errorf("Publish = %v, want ErrNotApplied", err)
+	}
+}
+
+func TestPublishOutOfOrderStatus(t *testing.T) {
+	broker, store := newBroker()
+
+	err := broker.Publish("hermes/"+testMAC+"/status", []byte(`{"status": "online", "timestamp": "2020-01-01T11:00:00Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = broker.Publish("hermes/"+testMAC+"/status", []byte(`{"status": "offline", "timestamp": "2020-01-01T10:00:00Z"}`))
+	if err != ErrNotApplied {
+		t.Errorf("Publish = %v, want ErrNotApplied", err)
+	}
+	if store.Status(testMAC) != "online" {
+		t.Errorf("status = %s, want online", store.Status(testMAC))
+	}
+}
+
+func TestPublishTelemetry(t *testing.T) {
+	broker, store := newBroker()
+
+	err := broker.Publish("hermes/"+testMAC+"/telemetry", []byte(`{"metrics": {"temperature": 21.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Telemetry) != 1 || store.Telemetry[0].Metrics["temperature"] != 21.5 {
+		t.Fatalf("telemetry = %v, want temperature 21.5", store.Telemetry)
+	}
+	if !store.Telemetry[0].Timestamp.Equal(testNow) {
+		t.Errorf("timestamp = %v, want %v", store.Telemetry[0].Timestamp, testNow)
+	}
+}
+
+func TestPublishMalformedPayloads(t *testing.T) {
+	payloads := []struct {
+		kind    string
+		payload string
+	}{
+		{KindStatus, ``},
+		{KindStatus, `not json`},
+		{KindStatus, `{"status": 1}`},
+		{KindStatus, `{"status": "online", "timestamp": "yesterday"}`},
+		{KindStatus, `{"status": "online", "timestamp": "2020-01-01T13:00:00Z"}`},
+		{KindTelemetry, `{"metrics": {}}`},
+		{KindTelemetry, `{"metrics": {"temperature": "warm"}}`},
+		{KindTelemetry, `{"metrics": {"bad name": 1}}`},
+		{KindTelemetry, `[1, 2]`},
+	}
+
+	for _, p := range payloads {
+		broker, store := newBroker()
+		err := broker.Publish("hermes/"+testMAC+"/"+p.kind, []byte(p.payload))
+		if !isInvalid(err) {
+			t.Errorf("Publish %s %q = %v, want an InvalidMessageError", p.kind, p.payload, err)
+		}
+		if store.Status(testMAC) != "offline" || len(store.Telemetry) != 0 {
+			t.Errorf("Publish %s %q changed the store", p.kind, p.payload)
+		}
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{TopicFilter, "hermes/" + testMAC + "/status", true},
+		{TopicFilter, "hermes/" + testMAC, false},
+		{TopicFilter, "hermes/" + testMAC + "/status/extra", false},
+		{"hermes/#", "hermes/" + testMAC + "/status/extra", true},
+		{"other/+/+", "hermes/" + testMAC + "/status", false},
+	}
+	for _, c := range cases {
+		if got := TopicMatches(c.filter, c.topic); got != c.want {
+			t.Errorf("TopicMatches(%q, %q) = %v, want %v", c.filter, c.topic, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package ingest
+
+import (
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TelemetryTable hash key is MAC, range key is Timestamp
+const TelemetryTable = "device_telemetry"
+
+// telemetryRetention is how long telemetry is kept
+const telemetryRetention = 30 * 24 * time.Hour
+
+// Store applies device messages
+type Store interface {
+	// UpdateStatus sets the status of a registered device unless a
+	// newer status was already applied, ErrNotApplied otherwise
+	UpdateStatus(mac string, status string, at time.Time) error
+	// PutTelemetry records the metrics of a registered device,
+	// ErrNotApplied if the device is not registered
+	PutTelemetry(mac string, metrics map[string]float64, at time.Time) error
+}
+
+// DynamoStore applies messages to the devices and telemetry tables
+type DynamoStore struct {
+	Service *dynamodb.DynamoDB
+}
+
+// UpdateStatus sets Status and StatusAt of the device and records
+// the change in the audit log
+func (d *DynamoStore) UpdateStatus(mac string, status string, at time.Time) error {
+	statusAt := at.Format(audit.TimestampFormat)
+
+	// Messages can arrive out of order, StatusAt keeps a
+	// late message from overwriting a newer status
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String("devices"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		UpdateExpression:    aws.String("SET #S = :s, StatusAt = :t, LastSeen = :t"),
+		ConditionExpression: aws.String("attribute_exists(MAC) AND (attribute_not_exists(StatusAt) OR StatusAt <= :t)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {S: aws.String(status)},
+			":t": {S: aws.String(statusAt)},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	}
+
+	dynamoResponse, err := d.Service.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotApplied
+		}
+		return fmt.Errorf("error updating status of %s: %v", mac, err)
+	}
+
+	// Devices repeat their status, only actual changes are audited
+	previousStatus := dynamoResponse.Attributes["Status"]
+	if previousStatus != nil && aws.StringValue(previousStatus.S) == status {
+		return nil
+	}
+	changes := audit.Diff(
+		map[string]*dynamodb.AttributeValue{"Status": previousStatus},
+		map[string]*dynamodb.AttributeValue{"Status": {S: aws.String(status)}},
+	)
+
+	auditEntry := audit.Entry{
+		MAC:       mac,
+		Timestamp: time.Now().UTC().Format(audit.TimestampFormat),
+		Action:    audit.ActionReportStatus,
+		ActorID:   "device:" + mac,
+		Changes:   changes,
+	}
+	return audit.Record(d.Service, auditEntry)
+}
+
+// PutTelemetry records the metrics and the time the device was last seen
+func (d *DynamoStore) PutTelemetry(mac string, metrics map[string]float64, at time.Time) error {
+	dynamoUpdateInput := dynamodb.UpdateItemInput{
+		TableName: aws.String("devices"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		UpdateExpression:    aws.String("SET LastSeen = :t"),
+		ConditionExpression: aws.String("attribute_exists(MAC) AND (attribute_not_exists(LastSeen) OR LastSeen <= :t)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {S: aws.String(at.Format(audit.TimestampFormat))},
+		},
+	}
+
+	_, err := d.Service.UpdateItem(&dynamoUpdateInput)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("error updating last seen of %s: %v", mac, err)
+	}
+	if err != nil {
+		// Either the device is not registered or this is late
+		// telemetry, which is still worth keeping
+		dynamoGetInput := dynamodb.GetItemInput{
+			TableName: aws.String("devices"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"MAC": {S: aws.String(mac)},
+			},
+			ProjectionExpression: aws.String("MAC"),
+		}
+		dynamoResponse, err := d.Service.GetItem(&dynamoGetInput)
+		if err != nil {
+			return fmt.Errorf("error looking up %s: %v", mac, err)
+		}
+		if len(dynamoResponse.Item) == 0 {
+			return ErrNotApplied
+		}
+	}
+
+	values := make(map[string]*dynamodb.AttributeValue)
+	for name, value := range metrics {
+		values[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(value, 'f', -1, 64))}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(TelemetryTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"MAC":       {S: aws.String(mac)},
+			"Timestamp": {S: aws.String(at.Format(audit.TimestampFormat))},
+			"Metrics":   {M: values},
+			"ExpiresAt": {N: aws.String(strconv.FormatInt(at.Add(telemetryRetention).Unix(), 10))},
+		},
+	}
+
+	_, err = d.Service.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing telemetry of %s: %v", mac, err)
+	}
+	return nil
+}
+
+// Telemetry is a telemetry message recorded by a MemoryStore
+type Telemetry struct {
+	MAC       string
+	Metrics   map[string]float64
+	Timestamp time.Time
+}
+
+// MemoryStore keeps devices in memory
+// It stands in for the dynamo store when testing locally
+type MemoryStore struct {
+	mu        sync.Mutex
+	statuses  map[string]string
+	statusAt  map[string]time.Time
+	Telemetry []Telemetry
+}
+
+// NewMemoryStore returns a store knowing the given devices, all offline
+func NewMemoryStore(macs ...string) *MemoryStore {
+	m := &MemoryStore{
+		statuses: make(map[string]string),
+		statusAt: make(map[string]time.Time),
+	}
+	for _, mac := range macs {
+		m.statuses[mac] = "offline"
+	}
+	return m
+}
+
+// Status returns the status of the device
+func (m *MemoryStore) Status(mac string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.statuses[mac]
+}
+
+// UpdateStatus sets the status of a known device
+func (m *MemoryStore) UpdateStatus(mac string, status string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.statuses[mac]; !ok || at.Before(m.statusAt[mac]) {
+		return ErrNotApplied
+	}
+	m.statuses[mac] = status
+	m.statusAt[mac] = at
+	return nil
+}
+
+// PutTelemetry records the metrics of a known device
+func (m *MemoryStore) PutTelemetry(mac string, metrics map[string]float64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.statuses[mac]; !ok {
+		return ErrNotApplied
+	}
+	m.Telemetry = append(m.Telemetry, Telemetry{MAC: mac, Metrics: metrics, Timestamp: at})
+	return nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package live
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Message types pushed to connected clients
+const (
+	MessageDeviceCreated = "device.created"
+	MessageDeviceUpdated = "device.updated"
+)
+
+// ErrGone is returned when the client of a connection has disconnected
+// without API Gateway having called $disconnect yet
+var ErrGone = errors.New("connection gone")
+
+// Device is the device state pushed to clients
+type Device struct {
+	MAC    string `json:"mac"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	Status string `json:"status"`
+}
+
+// Message is pushed to every subscribed connection of the owner
+type Message struct {
+	Type   string `json:"type"`
+	Device Device `json:"device"`
+}
+
+// Poster pushes data to WebSocket connections through the API Gateway
+// management API
+// The vendored SDK predates that API, so requests are signed by hand
+type Poster struct {
+	// Endpoint is https://{api-id}.execute-api.{region}.amazonaws.com/{stage}
+	Endpoint string
+	Session  *session.Session
+	Client   *http.Client
+}
+
+// NewPoster returns a poster for the endpoint
+func NewPoster(sess *session.Session, endpoint string) *Poster {
+	return &Poster{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Session:  sess,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Post sends data to the connection
+func (p *Poster) Post(ctx context.Context, connectionID string, data []byte) error {
+	req, err := http.NewRequest("POST", p.Endpoint+"/@connections/"+url.PathEscape(connectionID), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	signer := v4.NewSigner(p.Session.Config.Credentials)
+	_, err = signer.Sign(req, bytes.NewReader(data), "execute-api", *p.Session.Config.Region, time.Now())
+	if err != nil {
+		return fmt.Errorf("error signing request: %v", err)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return ErrGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// Broadcast sends the message to every connection of the owner, or of
+// the members of the organization owning the device, that subscribed
+// to the device and forgets connections that are gone
+// It returns the errors of the connections it could not send to
+func Broadcast(ctx context.Context, dynamoService *dynamodb.DynamoDB, poster *Poster, owner string, message Message) (map[string]error, error) {
+	users, err := org.Users(dynamoService, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var connections []Connection
+	for _, userID := range users {
+		userConnections, err := ListConnections(dynamoService, userID)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, userConnections...)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	for _, connection := range connections {
+		if !connection.Wants(message.Device.MAC) {
+			continue
+		}
+
+		err = poster.Post(ctx, connection.ConnectionID, data)
+		if err == ErrGone {
+			err = DeleteConnection(dynamoService, connection.ConnectionID)
+		}
+		if err != nil {
+			failed[connection.ConnectionID] = err
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,163 @@
+package live
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// Dynamo table backing the connections
+const (
+	// ConnectionsTable hash key is ConnectionID
+	ConnectionsTable = "websocket_connections"
+	// OwnerIndex is a global secondary index of ConnectionsTable
+	// Hash key is Owner
+	OwnerIndex = "OwnerIndex"
+)
+
+// connectionLifetime is the longest API Gateway keeps a WebSocket
+// connection open, connections are expired after it in case
+// $disconnect never ran
+const connectionLifetime = 2 * time.Hour
+
+// Connection is a WebSocket connection of a signed in user
+type Connection struct {
+	ConnectionID string
+	Owner        string
+	// Subscribed is set once the client sent subscribe, nothing is
+	// pushed to it before that
+	Subscribed bool
+	// Devices limits the pushed devices, empty means every device
+	Devices     []string
+	ConnectedAt string
+}
+
+// Wants reports whether the connection wants updates of the device
+func (c Connection) Wants(mac string) bool {
+	if !c.Subscribed {
+		return false
+	}
+	if len(c.Devices) == 0 {
+		return true
+	}
+	for _, device := range c.Devices {
+		if device == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// PutConnection records a new connection
+func PutConnection(dynamoService *dynamodb.DynamoDB, connection Connection) error {
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(ConnectionsTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"ConnectionID": {S: aws.String(connection.ConnectionID)},
+			"Owner":        {S: aws.String(connection.Owner)},
+			"Subscribed":   {BOOL: aws.Bool(connection.Subscribed)},
+			"ConnectedAt":  {S: aws.String(connection.ConnectedAt)},
+			"ExpiresAt":    {N: aws.String(strconv.FormatInt(time.Now().Add(connectionLifetime).Unix(), 10))},
+		},
+	}
+	if len(connection.Devices) > 0 {
+		dynamoInput.Item["Devices"] = &dynamodb.AttributeValue{SS: aws.StringSlice(connection.Devices)}
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing connection %s: %v", connection.ConnectionID, err)
+	}
+	return nil
+}
+
+// Subscribe starts pushing updates of the devices to the connection,
+// every device of the owner when devices is empty
+func Subscribe(dynamoService *dynamodb.DynamoDB, connectionID string, devices []string) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ConnectionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionID": {S: aws.String(connectionID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ConnectionID)"),
+		UpdateExpression:    aws.String("SET Subscribed = :s REMOVE Devices"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {BOOL: aws.Bool(true)},
+		},
+	}
+	if len(devices) > 0 {
+		dynamoInput.UpdateExpression = aws.String("SET Subscribed = :s, Devices = :d")
+		dynamoInput.ExpressionAttributeValues[":d"] = &dynamodb.AttributeValue{SS: aws.StringSlice(devices)}
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error subscribing connection %s: %v", connectionID, err)
+	}
+	return nil
+}
+
+// ListConnections returns every connection of the owner
+func ListConnections(dynamoService *dynamodb.DynamoDB, owner string) ([]Connection, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(ConnectionsTable),
+		IndexName:              aws.String(OwnerIndex),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	connections := []Connection{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			connections = append(connections, connectionFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing connections of %s: %v", owner, err)
+	}
+
+	return connections, nil
+}
+
+// DeleteConnection forgets the connection
+func DeleteConnection(dynamoService *dynamodb.DynamoDB, connectionID string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(ConnectionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ConnectionID": {S: aws.String(connectionID)},
+		},
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error deleting connection %s: %v", connectionID, err)
+	}
+	return nil
+}
+
+func connectionFromItem(item map[string]*dynamodb.AttributeValue) Connection {
+	connection := Connection{
+		ConnectionID: aws.StringValue(item["ConnectionID"].S),
+	}
+	if item["Owner"] != nil {
+		connection.Owner = aws.StringValue(item["Owner"].S)
+	}
+	if item["ConnectedAt"] != nil {
+		connection.ConnectedAt = aws.StringValue(item["ConnectedAt"].S)
+	}
+	if item["Subscribed"] != nil {
+		connection.Subscribed = aws.BoolValue(item["Subscribed"].BOOL)
+	}
+	if item["Devices"] != nil {
+		connection.Devices = aws.StringValueSlice(item["Devices"].SS)
+	}
+	return connection
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+)
+
+// MFAPreferenceEvent defines the request structure of this MFA preference request
+type MFAPreferenceEvent struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// Response defines the response structure to this MFA preference request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// SetMFAPreference is the lambda function handler
+// it turns TOTP MFA of the user on or off
+// Turning it on requires a verified software token
+func SetMFAPreference(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	accessToken := auth.AccessToken(req.Headers)
+	if accessToken == "" {
+		resp := Response{
+			Message: auth.AccessTokenHeader + " header missing from request",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt MFAPreferenceEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Enabled == nil {
+		resp := Response{
+			Message: "enabled missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: aws.String(accessToken),
+		SoftwareTokenMfaSettings: &cognitoidentityprovider.SoftwareTokenMfaSettingsType{
+			Enabled:      evt.Enabled,
+			PreferredMfa: evt.Enabled,
+		},
+	}
+
+	_, err = cognitoService.SetUserMFAPreference(&cognitoInput)
+	if err != nil {
+		log.Println("Error setting MFA preference (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		case cognitoidentityprovider.ErrCodeInvalidParameterException:
+			statusCode, message = 409, "Verify a software token before enabling MFA"
+		case cognitoidentityprovider.ErrCodeNotAuthorizedException:
+			message = "Access token is invalid or expired"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "MFA Preference Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	state := "disabled"
+	if *evt.Enabled {
+		state = "enabled"
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully %s MFA", state),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, SetMFAPreference))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+)
+
+// Response defines the response structure to this software token request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+	// Secret is the TOTP secret, for authenticator apps that can not scan the URI
+	Secret string `json:"Secret,omitempty"`
+	// URI is the otpauth URI of the secret, usually shown as a QR code
+	URI string `json:"URI,omitempty"`
+}
+
+// AssociateSoftwareToken is the lambda function handler
+// it creates a TOTP secret for the user to add to their authenticator app
+// MFA is only enabled once a code of the app has been verified
+func AssociateSoftwareToken(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	accessToken := auth.AccessToken(req.Headers)
+	if accessToken == "" {
+		resp := Response{
+			Message: auth.AccessTokenHeader + " header missing from request",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	emailFromToken, _ := typedAuthorizer["email"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+	}
+
+	cognitoResponse, err := cognitoService.AssociateSoftwareToken(&cognitoInput)
+	if err != nil {
+		log.Println("Error associating software token (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		if statusCode == 401 {
+			message = "Access token is invalid or expired"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "MFA Setup Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	secret := aws.StringValue(cognitoResponse.SecretCode)
+
+	resp := Response{
+		Message: "Add the secret to an authenticator app and verify a code to finish setting up MFA",
+		Secret:  secret,
+		URI:     auth.OTPAuthURI(emailFromToken, secret),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, AssociateSoftwareToken))
+}
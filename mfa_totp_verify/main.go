@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+	"unicode/utf8"
+)
+
+// VerifySoftwareTokenEvent defines the request structure of this software token verification request
+type VerifySoftwareTokenEvent struct {
+	// Code is the current code of the authenticator app
+	Code string `json:"code"`
+	// DeviceName optionally names the authenticator, like "Work phone"
+	DeviceName string `json:"deviceName"`
+}
+
+// Response defines the response structure to this software token verification request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// VerifySoftwareToken is the lambda function handler
+// it checks a code of the authenticator app the secret was added to
+// and enables TOTP MFA for the user once it matches
+func VerifySoftwareToken(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	accessToken := auth.AccessToken(req.Headers)
+	if accessToken == "" {
+		resp := Response{
+			Message: auth.AccessTokenHeader + " header missing from request",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt VerifySoftwareTokenEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Code == "" {
+		resp := Response{
+			Message: "code missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the DeviceName
+	// Needs to be 50 characters or less
+	if utf8.RuneCountInString(evt.DeviceName) > 50 {
+		resp := Response{
+			Message: "Provided deviceName too long",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+		UserCode:    aws.String(evt.Code),
+	}
+	if evt.DeviceName != "" {
+		cognitoInput.FriendlyDeviceName = aws.String(evt.DeviceName)
+	}
+
+	cognitoResponse, err := cognitoService.VerifySoftwareToken(&cognitoInput)
+	if err != nil {
+		log.Println("Error verifying software token (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		// A code that does not match the secret
+		case cognitoidentityprovider.ErrCodeEnableSoftwareTokenMFAException:
+			statusCode, message = 400, "Invalid code provided"
+		case cognitoidentityprovider.ErrCodeNotAuthorizedException:
+			message = "Access token is invalid or expired"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "MFA Setup Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	if aws.StringValue(cognitoResponse.Status) != cognitoidentityprovider.VerifySoftwareTokenResponseTypeSuccess {
+		resp := Response{
+			Message: "Invalid code provided",
+			Error:   "MFA Setup Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+
+	// A verified token is not used until it is the preferred MFA
+	preferenceInput := cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: aws.String(accessToken),
+		SoftwareTokenMfaSettings: &cognitoidentityprovider.SoftwareTokenMfaSettingsType{
+			Enabled:      aws.Bool(true),
+			PreferredMfa: aws.Bool(true),
+		},
+	}
+
+	_, err = cognitoService.SetUserMFAPreference(&preferenceInput)
+	if err != nil {
+		log.Println("Error enabling MFA (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		resp := Response{
+			Message: message,
+			Error:   "MFA Setup Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully enabled MFA",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, VerifySoftwareToken))
+}
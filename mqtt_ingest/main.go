@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/ingest"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// IngestMessage is the lambda function handler
+// it is invoked by the IoT rule for every message devices publish to
+// hermes/{mac}/status and hermes/{mac}/telemetry
+// Devices authenticate with their IoT certificate, so there are no
+// cognito claims to check here
+func IngestMessage(ctx context.Context, message ingest.Message) error {
+	sess := session.Must(session.NewSession())
+
+	handler := ingest.Handler{
+		Store: &ingest.DynamoStore{Service: dynamodb.New(sess)},
+	}
+
+	err := handler.Handle(message)
+	if err != nil && ingest.IsDropped(err) {
+		log.Printf("Dropping message on %s: %v\n", message.Topic, err)
+		return nil
+	}
+	// Any other error makes the IoT rule retry the invocation
+	return err
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(IngestMessage)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	// codeLimit is shared with channel creation, it caps the
+	// confirmation codes sent for a user
+	codeLimit = auth.Limit{Requests: 10, Window: time.Hour}
+	// attemptLimit keeps the six digit code from being guessed
+	attemptLimit = auth.Limit{Requests: 5, Window: time.Hour}
+)
+
+// ChannelConfirmEvent defines the request structure of this channel confirmation request
+type ChannelConfirmEvent struct {
+	// Code is the code sent to the destination, without a code a new
+	// one is sent
+	Code string `json:"code"`
+}
+
+// Response defines the response structure to this channel confirmation request
+type Response struct {
+	Message string                `json:"Response"`
+	Error   string                `json:"Error"`
+	Channel *notify.ChannelConfig `json:"Channel,omitempty"`
+}
+
+// ConfirmChannel is the lambda function handler
+func ConfirmChannel(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	channelID := req.PathParameters["id"]
+	if channelID == "" {
+		resp := Response{
+			Message: "id missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt ChannelConfirmEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	channel, err := notify.GetChannel(dynamoService, subFromToken, channelID)
+	if err == notify.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("Notification channel not found: %s", channelID),
+			Error:   "Channel lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up channel (dynamo)", err)
+		resp := Response{
+			Message: "Error confirming notification channel",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if channel.Confirmed {
+		resp := Response{
+			Message: fmt.Sprintf("Notification channel %s is already confirmed", channelID),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	now := time.Now()
+
+	rateKey := "channel-confirm:" + channelID
+	limit := attemptLimit
+	if evt.Code == "" {
+		rateKey = "channel-code:" + subFromToken
+		limit = codeLimit
+	}
+	allowed, err := auth.Allow(dynamoService, rateKey, limit, now)
+	if err != nil {
+		log.Println("Error checking rate limit (dynamo):", err)
+		resp := Response{
+			Message: "Error confirming notification channel",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !allowed {
+		resp := Response{
+			Message: "Too many requests, try again later",
+			Error:   "Rate limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 429}, nil
+	}
+
+	if evt.Code == "" {
+		return resendCode(ctx, sess, dynamoService, channel, now)
+	}
+
+	err = notify.Confirm(dynamoService, subFromToken, channelID, evt.Code, now)
+	if err == notify.ErrInvalidCode {
+		resp := Response{
+			Message: "Confirmation code is invalid or expired",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+	if err != nil {
+		log.Println("Error confirming channel (dynamo)", err)
+		resp := Response{
+			Message: "Error confirming notification channel",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	channel.Confirmed = true
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully confirmed notification channel %s", channelID),
+		Channel: &channel,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+// resendCode replaces the confirmation code of the channel and sends
+// the new one to its destination
+func resendCode(ctx context.Context, sess *session.Session, dynamoService *dynamodb.DynamoDB, channel notify.ChannelConfig, now time.Time) (events.APIGatewayProxyResponse, error) {
+	senders := map[string]notify.Channel{
+		notify.ChannelEmail: &notify.SESChannel{Service: ses.New(sess), From: os.Getenv("NOTIFICATION_FROM_ADDRESS")},
+		notify.ChannelSMS:   &notify.SNSSMSChannel{Service: sns.New(sess)},
+	}
+	sender, ok := senders[channel.Type]
+	if !ok {
+		resp := Response{
+			Message: fmt.Sprintf("%s channels can not be confirmed with a code", channel.Type),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+
+	code := notify.NewConfirmationCode()
+	err := notify.SetConfirmationCode(dynamoService, channel.Owner, channel.ChannelID, code, now.Add(notify.CodeTTL))
+	if err == notify.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("Notification channel not found: %s", channel.ChannelID),
+			Error:   "Channel lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error storing confirmation code (dynamo)", err)
+		resp := Response{
+			Message: "Error sending confirmation code",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	err = sender.Send(ctx, channel.Destination, notify.ConfirmationMessage(code))
+	if err != nil {
+		log.Println("Error sending confirmation code:", err)
+		resp := Response{
+			Message: fmt.Sprintf("Error sending confirmation code to %s", channel.Destination),
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Sent a new confirmation code to %s", channel.Destination),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("NOTIFICATION_FROM_ADDRESS") == "" {
+		log.Fatal("NOTIFICATION_FROM_ADDRESS not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.NotificationWrite, ConfirmChannel))
+}
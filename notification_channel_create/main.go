@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"log"
+	"os"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxChannels is the number of notification channels a user can have
+const MaxChannels = 10
+
+// codeLimit caps the confirmation codes sent for a user, so channels
+// can not be created and deleted to message arbitrary destinations
+var codeLimit = auth.Limit{Requests: 10, Window: time.Hour}
+
+// ChannelCreateEvent defines the request structure of this channel creation request
+type ChannelCreateEvent struct {
+	Type string `json:"type"`
+	// Destination is an email address for email channels, an E.164
+	// phone number for sms channels and the device token for push channels
+	Destination string `json:"destination"`
+	Label       string `json:"label"`
+}
+
+// Response defines the response structure to this channel creation request
+type Response struct {
+	Message string                `json:"Response"`
+	Error   string                `json:"Error"`
+	Channel *notify.ChannelConfig `json:"Channel,omitempty"`
+}
+
+// CreateChannel is the lambda function handler
+func CreateChannel(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt ChannelCreateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Destination == "" {
+		resp := Response{
+			Message: "destination missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the Destination for the channel type
+	var validDestination bool
+	switch evt.Type {
+	case notify.ChannelEmail:
+		validDestination, _ = regexp.MatchString("(^[a-zA-Z0-9_.+-]+@[a-zA-Z0-9-]+.[a-zA-Z0-9-.]+$)", evt.Destination)
+	case notify.ChannelSMS:
+		validDestination, _ = regexp.MatchString("^\\+[1-9][0-9]{6,14}$", evt.Destination)
+	case notify.ChannelPush:
+		validDestination = len(evt.Destination) <= 4096
+	default:
+		resp := Response{
+			Message: "type can only have value 'email', 'sms' or 'push'",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if validDestination == false {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid destination provided for %s channel", evt.Type),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the Label
+	// Needs to be 50 characters or less
+	if utf8.RuneCountInString(evt.Label) > 50 {
+		resp := Response{
+			Message: "Provided label too long",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	existing, err := notify.ListChannels(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing channels (dynamo)", err)
+		resp := Response{
+			Message: "Error creating channel",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(existing) >= MaxChannels {
+		resp := Response{
+			Message: fmt.Sprintf("A user can have at most %d notification channels", MaxChannels),
+			Error:   "Limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	now := time.Now()
+
+	channel := notify.ChannelConfig{
+		ChannelID:   webhook.NewID(),
+		Owner:       subFromToken,
+		Type:        evt.Type,
+		Destination: evt.Destination,
+		Label:       evt.Label,
+		CreatedAt:   now.UTC().Format(time.RFC3339),
+	}
+
+	// Push tokens are registered with the SNS platform application,
+	// the resulting endpoint is what notifications are sent to
+	if evt.Type == notify.ChannelPush {
+		snsService := sns.New(sess)
+		channel.Destination, err = notify.CreatePushEndpoint(snsService, os.Getenv("PUSH_PLATFORM_APPLICATION_ARN"), evt.Destination, subFromToken)
+		if err != nil {
+			log.Println("Error creating push endpoint (sns)", err)
+			resp := Response{
+				Message: "Error registering push token",
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+		}
+		// The token was handed out to the app on the device itself,
+		// so it needs no further confirmation
+		channel.Confirmed = true
+	} else {
+		allowed, err := auth.Allow(dynamoService, "channel-code:"+subFromToken, codeLimit, now)
+		if err != nil {
+			log.Println("Error checking rate limit (dynamo):", err)
+			resp := Response{
+				Message: "Error creating channel",
+				Error:   "Something went wrong",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+		}
+		if !allowed {
+			resp := Response{
+				Message: "Too many confirmation codes sent, try again later",
+				Error:   "Rate limit exceeded",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 429}, nil
+		}
+
+		// Email and sms channels are only notified once the user
+		// entered the code sent to the destination
+		channel.ConfirmationCode = notify.NewConfirmationCode()
+		channel.CodeExpiresAt = now.Add(notify.CodeTTL).UTC().Format(time.RFC3339)
+
+		senders := map[string]notify.Channel{
+			notify.ChannelEmail: &notify.SESChannel{Service: ses.New(sess), From: os.Getenv("NOTIFICATION_FROM_ADDRESS")},
+			notify.ChannelSMS:   &notify.SNSSMSChannel{Service: sns.New(sess)},
+		}
+		err = senders[channel.Type].Send(ctx, channel.Destination, notify.ConfirmationMessage(channel.ConfirmationCode))
+		if err != nil {
+			log.Println("Error sending confirmation code:", err)
+			resp := Response{
+				Message: fmt.Sprintf("Error sending confirmation code to %s", channel.Destination),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+		}
+	}
+
+	err = notify.PutChannel(dynamoService, channel)
+	if err != nil {
+		log.Println("Error creating channel (dynamo)", err)
+		resp := Response{
+			Message: "Error creating channel",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	message := fmt.Sprintf("Successfully created %s channel %s", channel.Type, channel.ChannelID)
+	if !channel.Confirmed {
+		message = fmt.Sprintf("Created %s channel %s, confirm it with the code sent to %s", channel.Type, channel.ChannelID, channel.Destination)
+	}
+
+	resp := Response{
+		Message: message,
+		Channel: &channel,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("PUSH_PLATFORM_APPLICATION_ARN") == "" {
+		log.Fatal("PUSH_PLATFORM_APPLICATION_ARN not set")
+	}
+
+	if os.Getenv("NOTIFICATION_FROM_ADDRESS") == "" {
+		log.Fatal("NOTIFICATION_FROM_ADDRESS not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.NotificationWrite, CreateChannel))
+}
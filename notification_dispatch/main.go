@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/devicestream"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"log"
+	"os"
+)
+
+// DispatchNotifications is the lambda function handler
+// it consumes the devices table stream and notifies the owner of a
+// device, or the members of the organization owning it, over every
+// subscribed channel when the device goes offline or comes back online
+func DispatchNotifications(ctx context.Context, evt events.DynamoDBEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	snsService := sns.New(sess)
+
+	notifier := notify.Notifier{
+		Channels: map[string]notify.Channel{
+			notify.ChannelEmail: &notify.SESChannel{Service: ses.New(sess), From: os.Getenv("NOTIFICATION_FROM_ADDRESS")},
+			notify.ChannelSMS:   &notify.SNSSMSChannel{Service: snsService},
+			notify.ChannelPush:  &notify.SNSPushChannel{Service: snsService},
+		},
+		Deduper: &notify.DynamoDeduper{Service: dynamoService},
+	}
+
+	for _, record := range evt.Records {
+		if record.EventName != "MODIFY" {
+			continue
+		}
+
+		oldItem := devicestream.Item(record.Change.OldImage)
+		newItem := devicestream.Item(record.Change.NewImage)
+
+		status := devicestream.String(newItem, "Status")
+		if status == devicestream.String(oldItem, "Status") {
+			continue
+		}
+
+		var event string
+		switch status {
+		case "offline":
+			event = notify.EventDeviceOffline
+		case "online":
+			event = notify.EventDeviceOnline
+		default:
+			continue
+		}
+
+		mac := devicestream.String(newItem, "MAC")
+		owner := devicestream.String(newItem, "Owner")
+
+		message := notify.StatusMessage(event, mac, devicestream.String(newItem, "Name"))
+
+		// Devices of an organization notify every member that
+		// subscribed to them
+		users, err := org.Users(dynamoService, owner)
+		if err != nil {
+			return err
+		}
+
+		for _, userID := range users {
+			err = notifyUser(ctx, dynamoService, &notifier, userID, mac, event, message)
+			if err != nil {
+				// Returning the error makes lambda retry the batch,
+				// the deduper keeps already sent messages from repeating
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// notifyUser sends the message over every channel the user subscribed
+// to the event of the device with
+func notifyUser(ctx context.Context, dynamoService *dynamodb.DynamoDB, notifier *notify.Notifier, userID string, mac string, event string, message notify.Message) error {
+	subscription, err := notify.GetSubscription(dynamoService, userID, mac)
+	if err == notify.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !subscription.Subscribed(event) {
+		return nil
+	}
+
+	channels, err := notify.ListChannels(dynamoService, userID)
+	if err != nil {
+		return err
+	}
+	configured := make(map[string]notify.ChannelConfig)
+	for _, channel := range channels {
+		configured[channel.ChannelID] = channel
+	}
+
+	for _, channelID := range subscription.ChannelIDs {
+		channel, ok := configured[channelID]
+		if !ok {
+			// The channel was deleted after the subscription was made
+			continue
+		}
+		if !channel.Confirmed {
+			// Nothing is sent to a destination before the user proved
+			// it is theirs
+			continue
+		}
+
+		sent, err := notifier.Notify(ctx, channel, mac, event, subscription.Cooldown(), message)
+		if err != nil {
+			// A single bad destination should not hold up the
+			// rest of the stream, so this is only logged
+			log.Printf("Error notifying channel %s about %s: %v\n", channelID, mac, err)
+			continue
+		}
+		if !sent {
+			log.Printf("Suppressed %s notification of %s on channel %s\n", event, mac, channelID)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("NOTIFICATION_FROM_ADDRESS") == "" {
+		log.Fatal("NOTIFICATION_FROM_ADDRESS not set")
+	}
+
+	lambda.Start(DispatchNotifications)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+)
+
+// SubscriptionPutEvent defines the request structure of this subscription request
+type SubscriptionPutEvent struct {
+	Channels []string `json:"channels"`
+	// Events defaults to device.offline
+	Events []string `json:"events"`
+	// CooldownMinutes defaults to 15, at most 1440
+	CooldownMinutes int `json:"cooldownMinutes"`
+}
+
+// Response defines the response structure to this subscription request
+type Response struct {
+	Message      string               `json:"Response"`
+	Error        string               `json:"Error"`
+	Subscription *notify.Subscription `json:"Subscription,omitempty"`
+}
+
+// PutSubscription is the lambda function handler
+// it creates or replaces the notification rules of a device
+func PutSubscription(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	mac, err := url.PathUnescape(req.PathParameters["mac"])
+	if err != nil || mac == "" {
+		resp := Response{
+			Message: "mac missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the MAC
+	validMAC, err := regexp.MatchString("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$", mac)
+	if validMAC == false {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid MAC Address Provided: %s", mac),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt SubscriptionPutEvent
+	err = json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(evt.Channels) == 0 {
+		resp := Response{
+			Message: "channels missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(evt.Events) == 0 {
+		evt.Events = []string{notify.EventDeviceOffline}
+	}
+
+	// Validate the events
+	// Only status changes can be subscribed to
+	subscribedEvents := []string{}
+	seenEvents := make(map[string]bool)
+	for _, event := range evt.Events {
+		if event != notify.EventDeviceOffline && event != notify.EventDeviceOnline {
+			resp := Response{
+				Message: "events can only have value 'device.offline' or 'device.online'",
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+		if !seenEvents[event] {
+			seenEvents[event] = true
+			subscribedEvents = append(subscribedEvents, event)
+		}
+	}
+
+	// Validate the cooldown
+	if evt.CooldownMinutes < 0 || evt.CooldownMinutes > 1440 {
+		resp := Response{
+			Message: "cooldownMinutes must be between 0 and 1440",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	dynamoGetInput := dynamodb.GetItemInput{
+		TableName: aws.String("devices"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(mac)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoGetInput)
+	if err != nil {
+		log.Println("Error looking up device (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up MAC",
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(dynamoResponse.Item) == 0 {
+		resp := Response{
+			Message: fmt.Sprintf("MAC not found: %s", mac),
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+
+	// The device is owned by the user or by an organization the
+	// user sees devices of
+	authorized, err := org.Authorize(dynamoService, subFromToken, aws.StringValue(dynamoResponse.Item["Owner"].S), org.RoleViewer)
+	if err != nil {
+		log.Println("Error looking up membership (dynamo)", err)
+		resp := Response{
+			Message: "Error storing notification subscription",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !authorized {
+		resp := Response{
+			Message: "Not authorized to perform this action",
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	channels, err := notify.ListChannels(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing channels (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up notification channels",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	// Every channel must be one of the caller's
+	ownedChannels := make(map[string]bool)
+	for _, channel := range channels {
+		ownedChannels[channel.ChannelID] = true
+	}
+	subscribedChannels := []string{}
+	seenChannels := make(map[string]bool)
+	for _, channelID := range evt.Channels {
+		if !ownedChannels[channelID] {
+			resp := Response{
+				Message: fmt.Sprintf("Notification channel not found: %s", channelID),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+		}
+		if !seenChannels[channelID] {
+			seenChannels[channelID] = true
+			subscribedChannels = append(subscribedChannels, channelID)
+		}
+	}
+
+	subscription := notify.Subscription{
+		Owner:           subFromToken,
+		MAC:             mac,
+		ChannelIDs:      subscribedChannels,
+		Events:          subscribedEvents,
+		CooldownMinutes: evt.CooldownMinutes,
+		UpdatedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+
+	err = notify.PutSubscription(dynamoService, subscription)
+	if err != nil {
+		log.Println("Error storing subscription (dynamo)", err)
+		resp := Response{
+			Message: "Error storing notification subscription",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message:      fmt.Sprintf("Successfully updated notifications for device %s", mac),
+		Subscription: &subscription,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.NotificationWrite, PutSubscription))
+}
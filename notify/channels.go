@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// SESChannel sends email through SES
+type SESChannel struct {
+	Service *ses.SES
+	// From must be a verified SES identity
+	From string
+}
+
+// Send emails the message to the destination address
+func (c *SESChannel) Send(ctx context.Context, destination string, message Message) error {
+	sesInput := ses.SendEmailInput{
+		Source: aws.String(c.From),
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(destination)},
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(message.Subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(message.Body), Charset: aws.String("UTF-8")},
+			},
+		},
+	}
+
+	_, err := c.Service.SendEmailWithContext(ctx, &sesInput)
+	return err
+}
+
+// SNSSMSChannel sends text messages through SNS
+type SNSSMSChannel struct {
+	Service *sns.SNS
+}
+
+// Send texts the message body to the destination phone number
+func (c *SNSSMSChannel) Send(ctx context.Context, destination string, message Message) error {
+	snsInput := sns.PublishInput{
+		PhoneNumber: aws.String(destination),
+		Message:     aws.String(message.Body),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"AWS.SNS.SMS.SMSType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("Transactional"),
+			},
+		},
+	}
+
+	_, err := c.Service.PublishWithContext(ctx, &snsInput)
+	return err
+}
+
+// SNSPushChannel sends mobile push notifications through an SNS
+// platform application, which forwards them to FCM or APNS
+// The destination is the platform endpoint ARN of the device
+type SNSPushChannel struct {
+	Service *sns.SNS
+}
+
+// Send pushes the message to the platform endpoint
+func (c *SNSPushChannel) Send(ctx context.Context, destination string, message Message) error {
+	snsInput := sns.PublishInput{
+		TargetArn: aws.String(destination),
+		Subject:   aws.String(message.Subject),
+		Message:   aws.String(message.Body),
+	}
+
+	_, err := c.Service.PublishWithContext(ctx, &snsInput)
+	return err
+}
+
+// CreatePushEndpoint registers a device push token with the SNS
+// platform application and returns the endpoint ARN to send to
+func CreatePushEndpoint(snsService *sns.SNS, platformApplicationARN string, token string, owner string) (string, error) {
+	snsInput := sns.CreatePlatformEndpointInput{
+		PlatformApplicationArn: aws.String(platformApplicationARN),
+		Token:                  aws.String(token),
+		CustomUserData:         aws.String(owner),
+	}
+
+	snsResponse, err := snsService.CreatePlatformEndpoint(&snsInput)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(snsResponse.EndpointArn), nil
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"math/big"
+	"time"
+)
+
+// CodeTTL is how long a confirmation code can be used
+const CodeTTL = 24 * time.Hour
+
+// ErrInvalidCode is returned when a confirmation code is wrong or expired
+var ErrInvalidCode = errors.New("invalid confirmation code")
+
+// NewConfirmationCode returns a random six digit code
+func NewConfirmationCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// ConfirmationMessage returns the message sending the code to the
+// destination of a new channel
+func ConfirmationMessage(code string) Message {
+	return Message{
+		Subject: "Hermes: confirm your notification channel",
+		Body:    fmt.Sprintf("Your Hermes confirmation code is %s. If you did not add this notification channel, ignore this message.", code),
+	}
+}
+
+// SetConfirmationCode replaces the code of an unconfirmed channel
+// Confirmed and missing channels return ErrNotFound
+func SetConfirmationCode(dynamoService *dynamodb.DynamoDB, owner string, channelID string, code string, expiresAt time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ChannelsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"ChannelID": {S: aws.String(channelID)},
+		},
+		UpdateExpression:    aws.String("SET ConfirmationCode = :c, CodeExpiresAt = :e"),
+		ConditionExpression: aws.String("attribute_exists(ChannelID) AND (attribute_not_exists(Confirmed) OR Confirmed = :f)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(code)},
+			":e": {S: aws.String(expiresAt.UTC().Format(time.RFC3339))},
+			":f": {BOOL: aws.Bool(false)},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error storing confirmation code of channel %s: %v", channelID, err)
+	}
+	return nil
+}
+
+// Confirm marks the channel confirmed when the code matches the one
+// sent to it and has not expired, otherwise it returns ErrInvalidCode
+func Confirm(dynamoService *dynamodb.DynamoDB, owner string, channelID string, code string, now time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ChannelsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"ChannelID": {S: aws.String(channelID)},
+		},
+		UpdateExpression:    aws.String("SET Confirmed = :t REMOVE ConfirmationCode, CodeExpiresAt"),
+		ConditionExpression: aws.String("ConfirmationCode = :c AND CodeExpiresAt > :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {BOOL: aws.Bool(true)},
+			":c": {S: aws.String(code)},
+			":n": {S: aws.String(now.UTC().Format(time.RFC3339))},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrInvalidCode
+		}
+		return fmt.Errorf("error confirming channel %s: %v", channelID, err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewConfirmationCode(t *testing.T) {
+	format := regexp.MustCompile("^[0-9]{6}$")
+	for i := 0; i < 100; i++ {
+		code := NewConfirmationCode()
+		if !format.MatchString(code) {
+			t.Fatalf("NewConfirmationCode() = %q, want six digits", code)
+		}
+	}
+}
+
+func TestConfirmationMessage(t *testing.T) {
+	message := ConfirmationMessage("012345")
+	if !strings.Contains(message.Body, "012345") {
+		t.Errorf("body %q does not contain the code", message.Body)
+	}
+}
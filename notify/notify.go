@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Channel types a user can configure
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// Events a device subscription can ask for
+const (
+	EventDeviceOffline = "device.offline"
+	EventDeviceOnline  = "device.online"
+)
+
+// DefaultCooldown is how long the same event of the same device is
+// suppressed on a channel after it was sent, so that a flapping
+// device does not send a message on every change
+const DefaultCooldown = 15 * time.Minute
+
+// Message is what gets sent over a channel
+// Channels that have no subject, like SMS, only send the body
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Channel delivers messages to a destination
+// The destination is an email address, a phone number or a push
+// endpoint depending on the channel
+type Channel interface {
+	Send(ctx context.Context, destination string, message Message) error
+}
+
+// Deduper decides whether a notification identified by key may be
+// sent now, given it should be sent at most once per cooldown
+// Release gives up an acquisition whose message could not be sent, so
+// the next attempt is not suppressed
+type Deduper interface {
+	Acquire(key string, cooldown time.Duration) (bool, error)
+	Release(key string) error
+}
+
+// Notifier sends messages over the configured channels
+type Notifier struct {
+	// Channels is keyed by channel type
+	Channels map[string]Channel
+	Deduper  Deduper
+}
+
+// Notify sends the message to the channel unless the same event of
+// the same device was already sent to it within the cooldown
+// It reports whether the message was sent
+func (n *Notifier) Notify(ctx context.Context, config ChannelConfig, mac string, event string, cooldown time.Duration, message Message) (bool, error) {
+	channel, ok := n.Channels[config.Type]
+	if !ok {
+		return false, fmt.Errorf("no channel configured for type %s", config.Type)
+	}
+
+	key := config.ChannelID + "#" + mac + "#" + event
+	acquired, err := n.Deduper.Acquire(key, cooldown)
+	if err != nil {
+		return false, err
+	}
+	if !acquired {
+		return false, nil
+	}
+
+	err = channel.Send(ctx, config.Destination, message)
+	if err != nil {
+		releaseErr := n.Deduper.Release(key)
+		if releaseErr != nil {
+			return false, fmt.Errorf("error sending %s notification: %v, and error releasing it: %v", config.Type, err, releaseErr)
+		}
+		return false, fmt.Errorf("error sending %s notification: %v", config.Type, err)
+	}
+
+	return true, nil
+}
+
+// StatusMessage returns the message sent when a device changes status
+func StatusMessage(event string, mac string, name string) Message {
+	if name == "" {
+		name = mac
+	}
+	switch event {
+	case EventDeviceOffline:
+		return Message{
+			Subject: fmt.Sprintf("Hermes: %s is offline", name),
+			Body:    fmt.Sprintf("Your device %s (%s) went offline at %s.", name, mac, time.Now().UTC().Format(time.RFC1123)),
+		}
+	default:
+		return Message{
+			Subject: fmt.Sprintf("Hermes: %s is back online", name),
+			Body:    fmt.Sprintf("Your device %s (%s) came back online at %s.", name, mac, time.Now().UTC().Format(time.RFC1123)),
+		}
+	}
+}
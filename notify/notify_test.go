@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentMessage is a message recorded by a fakeChannel
+type sentMessage struct {
+	Destination string
+	Message     Message
+}
+
+// fakeChannel records messages instead of sending them
+type fakeChannel struct {
+	mu   sync.Mutex
+	Sent []sentMessage
+	// Err is returned by Send when set
+	Err error
+}
+
+func (f *fakeChannel) Send(ctx context.Context, destination string, message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Sent = append(f.Sent, sentMessage{Destination: destination, Message: message})
+	return nil
+}
+
+// memoryDeduper keeps the last send times in memory
+type memoryDeduper struct {
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	now      time.Time
+}
+
+func (m *memoryDeduper) Acquire(key string, cooldown time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastSent == nil {
+		m.lastSent = make(map[string]time.Time)
+	}
+	if last, ok := m.lastSent[key]; ok && m.now.Sub(last) < cooldown {
+		return false, nil
+	}
+	m.lastSent[key] = m.now
+	return true, nil
+}
+
+func (m *memoryDeduper) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.lastSent, key)
+	return nil
+}
+
+func newNotifier() (*Notifier, *fakeChannel, *fakeChannel, *memoryDeduper) {
+	email := &fakeChannel{}
+	sms := &fakeChannel{}
+	deduper := &memoryDeduper{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	notifier := &Notifier{
+		Channels: map[string]Channel{
+			ChannelEmail: email,
+			ChannelSMS:   sms,
+		},
+		Deduper: deduper,
+	}
+	return notifier, email, sms, deduper
+}
+
+var emailConfig = ChannelConfig{ChannelID: "c1", Type: ChannelEmail, Destination: "user@example.com"}
+
+func TestNotifyChannelSelection(t *testing.T) {
+	notifier, email, sms, _ := newNotifier()
+	message := Message{Subject: "subject", Body: "body"}
+
+	sent, err := notifier.Notify(context.Background(), emailConfig, "AA:BB:CC:DD:EE:FF", EventDeviceOffline, DefaultCooldown, message)
+	if err != nil || !sent {
+		t.Fatalf("Notify = %v, %v, want true, nil", sent, err)
+	}
+	if len(email.Sent) != 1 || email.Sent[0].Destination != "user@example.com" {
+		t.Errorf("email sent %v, want one message to user@example.com", email.Sent)
+	}
+	if len(sms.Sent) != 0 {
+		t.Errorf("sms sent %v, want nothing", sms.Sent)
+	}
+
+	smsConfig := ChannelConfig{ChannelID: "c2", Type: ChannelSMS, Destination: "+15550100"}
+	sent, err = notifier.Notify(context.Background(), smsConfig, "AA:BB:CC:DD:EE:FF", EventDeviceOffline, DefaultCooldown, message)
+	if err != nil || !sent {
+		t.Fatalf("Notify = %v, %v, want true, nil", sent, err)
+	}
+	if len(sms.Sent) != 1 || sms.Sent[0].Destination != "+15550100" {
+		t.Errorf("sms sent %v, want one message to +15550100", sms.Sent)
+	}
+}
+
+func TestNotifyUnknownChannel(t *testing.T) {
+	notifier, _, _, _ := newNotifier()
+	pushConfig := ChannelConfig{ChannelID: "c3", Type: ChannelPush, Destination: "arn"}
+
+	sent, err := notifier.Notify(context.Background(), pushConfig, "AA:BB:CC:DD:EE:FF", EventDeviceOffline, DefaultCooldown, Message{})
+	if err == nil || sent {
+		t.Fatalf("Notify = %v, %v, want false and an error", sent, err)
+	}
+}
+
+func TestNotifyDedup(t *testing.T) {
+	notifier, email, _, deduper := newNotifier()
+	mac := "AA:BB:CC:DD:EE:FF"
+
+	for i := 0; i < 2; i++ {
+		_, err := notifier.Notify(context.Background(), emailConfig, mac, EventDeviceOffline, DefaultCooldown, Message{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(email.Sent) != 1 {
+		t.Fatalf("sent %d messages within the cooldown, want 1", len(email.Sent))
+	}
+
+	// Other events of the same device are not suppressed
+	sent, err := notifier.Notify(context.Background(), emailConfig, mac, EventDeviceOnline, DefaultCooldown, Message{})
+	if err != nil || !sent {
+		t.Fatalf("Notify = %v, %v, want true, nil", sent, err)
+	}
+
+	deduper.now = deduper.now.Add(DefaultCooldown)
+	sent, err = notifier.Notify(context.Background(), emailConfig, mac, EventDeviceOffline, DefaultCooldown, Message{})
+	if err != nil || !sent {
+		t.Fatalf("Notify after the cooldown = %v, %v, want true, nil", sent, err)
+	}
+}
+
+func TestNotifySendErrorReleases(t *testing.T) {
+	notifier, email, _, _ := newNotifier()
+	mac := "AA:BB:CC:DD:EE:FF"
+
+	email.Err = errors.New("throttled")
+	sent, err := notifier.Notify(context.Background(), emailConfig, mac, EventDeviceOffline, DefaultCooldown, Message{})
+	if err == nil || sent {
+		t.Fatalf("Notify = %v, %v, want false and an error", sent, err)
+	}
+
+	email.Err = nil
+	sent, err = notifier.Notify(context.Background(), emailConfig, mac, EventDeviceOffline, DefaultCooldown, Message{})
+	if err != nil || !sent {
+		t.Fatalf("Notify after a failed send = %v, %v, want true, nil", sent, err)
+	}
+}
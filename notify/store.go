@@ -0,0 +1,362 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+// Dynamo tables backing the notifications
+const (
+	// ChannelsTable hash key is Owner, range key is ChannelID
+	ChannelsTable = "notification_channels"
+	// SubscriptionsTable hash key is Owner, range key is MAC
+	SubscriptionsTable = "notification_subscriptions"
+	// DedupeTable hash key is DedupeKey
+	DedupeTable = "notification_dedupe"
+)
+
+// ErrNotFound is returned when a channel or subscription does not exist
+var ErrNotFound = errors.New("not found")
+
+// ChannelConfig is a channel configured by a user
+type ChannelConfig struct {
+	ChannelID string `json:"id"`
+	Owner     string `json:"owner"`
+	Type      string `json:"type"`
+	// Destination is the email address, phone number or
+	// push endpoint ARN the channel sends to
+	Destination string `json:"destination"`
+	Label       string `json:"label,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+	// Confirmed is set once the user entered the code sent to the
+	// destination, notifications are only sent to confirmed channels
+	Confirmed bool `json:"confirmed"`
+	// ConfirmationCode is the code sent to the destination, valid
+	// until CodeExpiresAt
+	ConfirmationCode string `json:"-"`
+	CodeExpiresAt    string `json:"-"`
+}
+
+// Subscription describes which channels are told about which
+// events of a single device
+type Subscription struct {
+	Owner           string   `json:"owner"`
+	MAC             string   `json:"mac"`
+	ChannelIDs      []string `json:"channels"`
+	Events          []string `json:"events"`
+	CooldownMinutes int      `json:"cooldownMinutes"`
+	UpdatedAt       string   `json:"updatedAt"`
+}
+
+// Cooldown returns the cooldown of the subscription
+func (s Subscription) Cooldown() time.Duration {
+	if s.CooldownMinutes <= 0 {
+		return DefaultCooldown
+	}
+	return time.Duration(s.CooldownMinutes) * time.Minute
+}
+
+// Subscribed reports whether the subscription wants the event
+func (s Subscription) Subscribed(event string) bool {
+	for _, subscribed := range s.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// PutChannel stores the channel
+func PutChannel(dynamoService *dynamodb.DynamoDB, config ChannelConfig) error {
+	// TODO: dynamodbattribute.MarshalMap does not work!? Need to figure out why, for now, we'll make the
+	// required structs manually
+	item := map[string]*dynamodb.AttributeValue{
+		"Owner":       {S: aws.String(config.Owner)},
+		"ChannelID":   {S: aws.String(config.ChannelID)},
+		"Type":        {S: aws.String(config.Type)},
+		"Destination": {S: aws.String(config.Destination)},
+		"CreatedAt":   {S: aws.String(config.CreatedAt)},
+		"Confirmed":   {BOOL: aws.Bool(config.Confirmed)},
+	}
+	if config.Label != "" {
+		item["Label"] = &dynamodb.AttributeValue{S: aws.String(config.Label)}
+	}
+	if config.ConfirmationCode != "" {
+		item["ConfirmationCode"] = &dynamodb.AttributeValue{S: aws.String(config.ConfirmationCode)}
+		item["CodeExpiresAt"] = &dynamodb.AttributeValue{S: aws.String(config.CodeExpiresAt)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName:           aws.String(ChannelsTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ChannelID)"),
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing channel %s: %v", config.ChannelID, err)
+	}
+	return nil
+}
+
+// ListChannels returns every channel of the owner
+func ListChannels(dynamoService *dynamodb.DynamoDB, owner string) ([]ChannelConfig, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(ChannelsTable),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	channels := []ChannelConfig{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			channels = append(channels, channelFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing channels of %s: %v", owner, err)
+	}
+
+	return channels, nil
+}
+
+// GetChannel returns the channel or ErrNotFound
+func GetChannel(dynamoService *dynamodb.DynamoDB, owner string, channelID string) (ChannelConfig, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(ChannelsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"ChannelID": {S: aws.String(channelID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return ChannelConfig{}, fmt.Errorf("error looking up channel %s: %v", channelID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return ChannelConfig{}, ErrNotFound
+	}
+
+	return channelFromItem(dynamoResponse.Item), nil
+}
+
+// DeleteChannel removes the channel or returns ErrNotFound
+// Subscriptions still naming the channel simply skip it
+func DeleteChannel(dynamoService *dynamodb.DynamoDB, owner string, channelID string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(ChannelsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"ChannelID": {S: aws.String(channelID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ChannelID)"),
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting channel %s: %v", channelID, err)
+	}
+	return nil
+}
+
+// SetDestination changes where the channel sends to or returns ErrNotFound
+func SetDestination(dynamoService *dynamodb.DynamoDB, owner string, channelID string, destination string) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(ChannelsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"ChannelID": {S: aws.String(channelID)},
+		},
+		UpdateExpression:    aws.String("SET Destination = :d"),
+		ConditionExpression: aws.String("attribute_exists(ChannelID)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":d": {S: aws.String(destination)},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error updating channel %s: %v", channelID, err)
+	}
+	return nil
+}
+
+// PutSubscription creates or replaces the subscription of a device
+func PutSubscription(dynamoService *dynamodb.DynamoDB, subscription Subscription) error {
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Owner":           {S: aws.String(subscription.Owner)},
+			"MAC":             {S: aws.String(subscription.MAC)},
+			"ChannelIDs":      {SS: aws.StringSlice(subscription.ChannelIDs)},
+			"Events":          {SS: aws.StringSlice(subscription.Events)},
+			"CooldownMinutes": {N: aws.String(strconv.Itoa(subscription.CooldownMinutes))},
+			"UpdatedAt":       {S: aws.String(subscription.UpdatedAt)},
+		},
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing subscription for %s: %v", subscription.MAC, err)
+	}
+	return nil
+}
+
+// GetSubscription returns the subscription of a device or ErrNotFound
+func GetSubscription(dynamoService *dynamodb.DynamoDB, owner string, mac string) (Subscription, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner": {S: aws.String(owner)},
+			"MAC":   {S: aws.String(mac)},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("error looking up subscription for %s: %v", mac, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Subscription{}, ErrNotFound
+	}
+
+	item := dynamoResponse.Item
+	subscription := Subscription{
+		Owner:     stringValue(item["Owner"]),
+		MAC:       stringValue(item["MAC"]),
+		UpdatedAt: stringValue(item["UpdatedAt"]),
+	}
+	if item["ChannelIDs"] != nil {
+		subscription.ChannelIDs = aws.StringValueSlice(item["ChannelIDs"].SS)
+	}
+	if item["Events"] != nil {
+		subscription.Events = aws.StringValueSlice(item["Events"].SS)
+	}
+	if item["CooldownMinutes"] != nil && item["CooldownMinutes"].N != nil {
+		subscription.CooldownMinutes, _ = strconv.Atoi(*item["CooldownMinutes"].N)
+	}
+
+	return subscription, nil
+}
+
+// DeleteSubscription removes the subscription of a device or returns ErrNotFound
+func DeleteSubscription(dynamoService *dynamodb.DynamoDB, owner string, mac string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner": {S: aws.String(owner)},
+			"MAC":   {S: aws.String(mac)},
+		},
+		ConditionExpression: aws.String("attribute_exists(MAC)"),
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting subscription for %s: %v", mac, err)
+	}
+	return nil
+}
+
+// DynamoDeduper records send times in the dedupe table
+// The conditional write makes concurrent invocations agree on
+// which one gets to send
+type DynamoDeduper struct {
+	Service *dynamodb.DynamoDB
+}
+
+// Acquire reports whether key was not acquired within the cooldown
+// and records the acquisition if so
+func (d *DynamoDeduper) Acquire(key string, cooldown time.Duration) (bool, error) {
+	now := time.Now()
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(DedupeTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"DedupeKey": {S: aws.String(key)},
+			"SentAt":    {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			"ExpiresAt": {N: aws.String(strconv.FormatInt(now.Add(cooldown).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(DedupeKey) OR SentAt <= :cutoff"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cutoff": {N: aws.String(strconv.FormatInt(now.Add(-cooldown).Unix(), 10))},
+		},
+	}
+
+	_, err := d.Service.PutItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error recording notification %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// Release deletes the acquisition of key
+func (d *DynamoDeduper) Release(key string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(DedupeTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"DedupeKey": {S: aws.String(key)},
+		},
+	}
+
+	_, err := d.Service.DeleteItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error releasing notification %s: %v", key, err)
+	}
+	return nil
+}
+
+// channelFromItem reads a channel item, email and sms channels stored
+// before they had to be confirmed have no Confirmed attribute and are
+// unconfirmed, push channels never need confirming
+func channelFromItem(item map[string]*dynamodb.AttributeValue) ChannelConfig {
+	return ChannelConfig{
+		ChannelID:        stringValue(item["ChannelID"]),
+		Owner:            stringValue(item["Owner"]),
+		Type:             stringValue(item["Type"]),
+		Destination:      stringValue(item["Destination"]),
+		Label:            stringValue(item["Label"]),
+		CreatedAt:        stringValue(item["CreatedAt"]),
+		Confirmed:        stringValue(item["Type"]) == ChannelPush || (item["Confirmed"] != nil && aws.BoolValue(item["Confirmed"].BOOL)),
+		ConfirmationCode: stringValue(item["ConfirmationCode"]),
+		CodeExpiresAt:    stringValue(item["CodeExpiresAt"]),
+	}
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
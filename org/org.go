@@ -0,0 +1,473 @@
+package org
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+)
+
+// Dynamo tables backing the organizations
+const (
+	// OrganizationsTable hash key is OrgID
+	OrganizationsTable = "organizations"
+	// MembersTable hash key is OrgID, range key is userID
+	MembersTable = "organization_members"
+	// UserIndex is the global secondary index of MembersTable
+	// Hash key is userID, range key is OrgID
+	UserIndex = "UserIndex"
+)
+
+// OwnerPrefix starts the Owner of devices owned by an organization, the
+// OrgID follows it
+// A cognito sub never contains a colon, so the two can not be confused
+const OwnerPrefix = "org:"
+
+// Roles a member can have, every role can do what the roles after it can
+const (
+	// RoleAdmin manages the members and the devices
+	RoleAdmin = "admin"
+	// RoleOperator registers and updates devices
+	RoleOperator = "operator"
+	// RoleViewer sees the devices and their audit log
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ErrNotFound is returned when an organization or member does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrLastAdmin is returned when a change would leave an organization without an admin
+var ErrLastAdmin = errors.New("organization needs an admin")
+
+// Organization is a group of users sharing devices
+type Organization struct {
+	OrgID     string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"`
+	// Role is the role of the user the organization was listed for
+	Role string `json:"role,omitempty"`
+}
+
+// Member is the membership of a user in an organization
+type Member struct {
+	OrgID  string `json:"-"`
+	UserID string `json:"userID"`
+	// Email is the email of the member, for display
+	// It follows email changes of the user
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	AddedAt string `json:"addedAt"`
+}
+
+// ValidRole reports whether a member can have the role
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// Can reports whether the member has at least the role
+func (m Member) Can(role string) bool {
+	return roleRanks[m.Role] >= roleRanks[role]
+}
+
+// Owner returns the device Owner of the organization
+func Owner(orgID string) string {
+	return OwnerPrefix + orgID
+}
+
+// OrgID returns the organization owning a device with the Owner
+func OrgID(owner string) (string, bool) {
+	if !strings.HasPrefix(owner, OwnerPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(owner, OwnerPrefix), true
+}
+
+// Create stores the organization with its creator as the first admin
+func Create(dynamoService *dynamodb.DynamoDB, organization Organization, admin Member) error {
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(OrganizationsTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"OrgID":     {S: aws.String(organization.OrgID)},
+			"Name":      {S: aws.String(organization.Name)},
+			"CreatedAt": {S: aws.String(organization.CreatedAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(OrgID)"),
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing organization %s: %v", organization.OrgID, err)
+	}
+
+	admin.OrgID = organization.OrgID
+	admin.Role = RoleAdmin
+	return putMember(dynamoService, admin)
+}
+
+// GetOrganization returns the organization or ErrNotFound
+func GetOrganization(dynamoService *dynamodb.DynamoDB, orgID string) (Organization, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(OrganizationsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"OrgID": {S: aws.String(orgID)},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Organization{}, fmt.Errorf("error looking up organization %s: %v", orgID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Organization{}, ErrNotFound
+	}
+
+	return Organization{
+		OrgID:     stringValue(dynamoResponse.Item["OrgID"]),
+		Name:      stringValue(dynamoResponse.Item["Name"]),
+		CreatedAt: stringValue(dynamoResponse.Item["CreatedAt"]),
+	}, nil
+}
+
+// ListOrganizations returns the organizations the user is a member of
+// along with their role in each
+func ListOrganizations(dynamoService *dynamodb.DynamoDB, userID string) ([]Organization, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(MembersTable),
+		IndexName:              aws.String(UserIndex),
+		KeyConditionExpression: aws.String("userID = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {S: aws.String(userID)},
+		},
+	}
+
+	var memberships []Member
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			memberships = append(memberships, memberFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing organizations of %s: %v", userID, err)
+	}
+
+	organizations := []Organization{}
+	for _, membership := range memberships {
+		organization, err := GetOrganization(dynamoService, membership.OrgID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		organization.Role = membership.Role
+		organizations = append(organizations, organization)
+	}
+
+	return organizations, nil
+}
+
+// GetMember returns the membership of the user or ErrNotFound
+func GetMember(dynamoService *dynamodb.DynamoDB, orgID string, userID string) (Member, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(MembersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"OrgID":  {S: aws.String(orgID)},
+			"userID": {S: aws.String(userID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Member{}, fmt.Errorf("error looking up member %s of %s: %v", userID, orgID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Member{}, ErrNotFound
+	}
+
+	return memberFromItem(dynamoResponse.Item), nil
+}
+
+// ListMembers returns every member of the organization
+func ListMembers(dynamoService *dynamodb.DynamoDB, orgID string) ([]Member, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(MembersTable),
+		KeyConditionExpression: aws.String("OrgID = :o"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(orgID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	members := []Member{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			members = append(members, memberFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing members of %s: %v", orgID, err)
+	}
+
+	return members, nil
+}
+
+// Users returns the users told about changes to the devices of the
+// owner, the owner itself or every member of the organization
+func Users(dynamoService *dynamodb.DynamoDB, owner string) ([]string, error) {
+	orgID, ok := OrgID(owner)
+	if !ok {
+		return []string{owner}, nil
+	}
+
+	members, err := ListMembers(dynamoService, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(members))
+	for _, member := range members {
+		users = append(users, member.UserID)
+	}
+	return users, nil
+}
+
+// PutMember adds the member or changes their role
+// Demoting the last admin returns ErrLastAdmin
+func PutMember(dynamoService *dynamodb.DynamoDB, member Member) error {
+	if member.Role != RoleAdmin {
+		err := keepAdmin(dynamoService, member.OrgID, member.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	return putMember(dynamoService, member)
+}
+
+// RemoveMember removes the member from the organization
+// Removing the last admin returns ErrLastAdmin
+func RemoveMember(dynamoService *dynamodb.DynamoDB, orgID string, userID string) error {
+	err := keepAdmin(dynamoService, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(MembersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"OrgID":  {S: aws.String(orgID)},
+			"userID": {S: aws.String(userID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(userID)"),
+	}
+
+	_, err = dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error removing member %s of %s: %v", userID, orgID, err)
+	}
+	return nil
+}
+
+// LastAdminOf returns the organizations the user is the only admin of
+// while they have other members, which would be left without an admin
+// if the user went away
+func LastAdminOf(dynamoService *dynamodb.DynamoDB, userID string) ([]Organization, error) {
+	organizations, err := ListOrganizations(dynamoService, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lastAdminOf := []Organization{}
+	for _, organization := range organizations {
+		if organization.Role != RoleAdmin {
+			continue
+		}
+		members, err := ListMembers(dynamoService, organization.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 1 && keepAdmin(dynamoService, organization.OrgID, userID) == ErrLastAdmin {
+			lastAdminOf = append(lastAdminOf, organization)
+		}
+	}
+	return lastAdminOf, nil
+}
+
+// Leave removes the user from the organization
+// When the user is the last admin, the member added first becomes admin
+// so the organization can still be managed
+func Leave(dynamoService *dynamodb.DynamoDB, orgID string, userID string) error {
+	err := keepAdmin(dynamoService, orgID, userID)
+	if err == ErrLastAdmin {
+		members, err := ListMembers(dynamoService, orgID)
+		if err != nil {
+			return err
+		}
+
+		var successor *Member
+		for i, member := range members {
+			if member.UserID == userID {
+				continue
+			}
+			// AddedAt is RFC3339 in UTC, so it sorts by time
+			if successor == nil || member.AddedAt < successor.AddedAt {
+				successor = &members[i]
+			}
+		}
+		if successor == nil {
+			return ErrLastAdmin
+		}
+
+		successor.Role = RoleAdmin
+		err = putMember(dynamoService, *successor)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	err = RemoveMember(dynamoService, orgID, userID)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Delete deletes the organization and every membership of it
+// Its devices have to be deleted before
+func Delete(dynamoService *dynamodb.DynamoDB, orgID string) error {
+	members, err := ListMembers(dynamoService, orgID)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		dynamoInput := dynamodb.DeleteItemInput{
+			TableName: aws.String(MembersTable),
+			Key: map[string]*dynamodb.AttributeValue{
+				"OrgID":  {S: aws.String(orgID)},
+				"userID": {S: aws.String(member.UserID)},
+			},
+		}
+		_, err = dynamoService.DeleteItem(&dynamoInput)
+		if err != nil {
+			return fmt.Errorf("error removing member %s of %s: %v", member.UserID, orgID, err)
+		}
+	}
+
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(OrganizationsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"OrgID": {S: aws.String(orgID)},
+		},
+	}
+	_, err = dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error deleting organization %s: %v", orgID, err)
+	}
+	return nil
+}
+
+// Authorize reports whether the user has at least the role on the
+// devices of the owner
+// Users have every role on their own devices, the devices of an
+// organization need a membership with the role
+func Authorize(dynamoService *dynamodb.DynamoDB, userID string, owner string, role string) (bool, error) {
+	if owner == userID {
+		return true, nil
+	}
+
+	orgID, ok := OrgID(owner)
+	if !ok {
+		return false, nil
+	}
+
+	member, err := GetMember(dynamoService, orgID, userID)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return member.Can(role), nil
+}
+
+// keepAdmin returns ErrLastAdmin when the user is the only admin left
+func keepAdmin(dynamoService *dynamodb.DynamoDB, orgID string, userID string) error {
+	members, err := ListMembers(dynamoService, orgID)
+	if err != nil {
+		return err
+	}
+
+	// Concurrent changes could still remove both of two admins, which
+	// is rare enough to be fixed by hand
+	var admins int
+	var isAdmin bool
+	for _, member := range members {
+		if member.Role == RoleAdmin {
+			admins++
+			isAdmin = isAdmin || member.UserID == userID
+		}
+	}
+	if isAdmin && admins == 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func putMember(dynamoService *dynamodb.DynamoDB, member Member) error {
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(MembersTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"OrgID":   {S: aws.String(member.OrgID)},
+			"userID":  {S: aws.String(member.UserID)},
+			"Email":   {S: aws.String(member.Email)},
+			"Role":    {S: aws.String(member.Role)},
+			"AddedAt": {S: aws.String(member.AddedAt)},
+		},
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing member %s of %s: %v", member.UserID, member.OrgID, err)
+	}
+	return nil
+}
+
+func memberFromItem(item map[string]*dynamodb.AttributeValue) Member {
+	return Member{
+		OrgID:   stringValue(item["OrgID"]),
+		UserID:  stringValue(item["userID"]),
+		Email:   stringValue(item["Email"]),
+		Role:    stringValue(item["Role"]),
+		AddedAt: stringValue(item["AddedAt"]),
+	}
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxOrganizations is the number of organizations a user can be a member of
+const MaxOrganizations = 10
+
+// OrganizationCreateEvent defines the request structure of this organization creation request
+type OrganizationCreateEvent struct {
+	Name string `json:"name"`
+}
+
+// Response defines the response structure to this organization creation request
+type Response struct {
+	Message      string            `json:"Response"`
+	Error        string            `json:"Error"`
+	Organization *org.Organization `json:"Organization,omitempty"`
+}
+
+// CreateOrganization is the lambda function handler
+// it creates an organization with the logged in user as its admin
+func CreateOrganization(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt OrganizationCreateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	name := strings.TrimSpace(evt.Name)
+	if name == "" || utf8.RuneCountInString(name) > 50 {
+		resp := Response{
+			Message: "name needs to be between 1 and 50 characters long",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	emailFromToken, _ := typedAuthorizer["email"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	existing, err := org.ListOrganizations(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing organizations (dynamo)", err)
+		resp := Response{
+			Message: "Error creating organization",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(existing) >= MaxOrganizations {
+		resp := Response{
+			Message: fmt.Sprintf("A user can be a member of at most %d organizations", MaxOrganizations),
+			Error:   "Limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	organization := org.Organization{
+		OrgID:     webhook.NewID(),
+		Name:      name,
+		CreatedAt: now,
+		Role:      org.RoleAdmin,
+	}
+
+	err = org.Create(dynamoService, organization, org.Member{
+		UserID:  subFromToken,
+		Email:   emailFromToken,
+		AddedAt: now,
+	})
+	if err != nil {
+		log.Println("Error creating organization (dynamo)", err)
+		resp := Response{
+			Message: "Error creating organization",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message:      fmt.Sprintf("Successfully created organization %s", organization.OrgID),
+		Organization: &organization,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.OrganizationWrite, CreateOrganization))
+}
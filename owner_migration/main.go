@@ -0,0 +1,278 @@
+// Command owner_migration rewrites the Owner of every item stored before ownership
+// was keyed by the cognito sub instead of the email of the user
+// It is not a lambda function, run it once against the stage with
+//
+//	go run owner_migration/main.go -user-pool-id <pool id> [-dry-run]
+//
+// Items whose Owner is already a sub are left alone, so it can be
+// run again after it was interrupted
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/alert"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/admin"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/live"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/schedule"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// ownedTable is a table storing an Owner
+type ownedTable struct {
+	name     string
+	keyNames []string
+	// ownerKey is set when the Owner is the hash key, the items are then
+	// copied under the new key and the old ones deleted
+	ownerKey bool
+}
+
+var ownedTables = []ownedTable{
+	{device.TableName, []string{"MAC"}, false},
+	{alert.StatesTable, []string{"RuleID", "MAC"}, false},
+	{webhook.DeliveriesTable, []string{"WebhookID", "DeliveryID"}, false},
+	{webhook.DeadLettersTable, []string{"WebhookID", "DeliveryID"}, false},
+	{live.ConnectionsTable, []string{"ConnectionID"}, false},
+	{webhook.SubscriptionsTable, []string{"Owner", "WebhookID"}, true},
+	{notify.ChannelsTable, []string{"Owner", "ChannelID"}, true},
+	{notify.SubscriptionsTable, []string{"Owner", "MAC"}, true},
+	{alert.RulesTable, []string{"Owner", "RuleID"}, true},
+	{alert.HistoryTable, []string{"Owner", "HistoryID"}, true},
+	{schedule.SchedulesTable, []string{"Owner", "ScheduleID"}, true},
+}
+
+// resolver looks the sub of users up by their email
+type resolver struct {
+	cognitoService *cognitoidentityprovider.CognitoIdentityProvider
+	userPoolID     string
+	subs           map[string]string
+}
+
+// sub returns the cognito sub of the user with the email, or an empty
+// string when there is no such user anymore
+func (r *resolver) sub(email string) (string, error) {
+	if sub, ok := r.subs[auth.NormalizeEmail(email)]; ok {
+		return sub, nil
+	}
+
+	// Users registered with their email as username, in whatever case
+	// they typed it, so try that first
+	cognitoResponse, err := r.cognitoService.AdminGetUser(&cognitoidentityprovider.AdminGetUserInput{
+		Username:   aws.String(email),
+		UserPoolId: aws.String(r.userPoolID),
+	})
+	if err == nil {
+		sub := attribute(cognitoResponse.UserAttributes, "sub")
+		r.subs[auth.NormalizeEmail(email)] = sub
+		return sub, nil
+	}
+	if auth.Code(err) != cognitoidentityprovider.ErrCodeUserNotFoundException {
+		return "", fmt.Errorf("error getting cognito user %s: %v", email, err)
+	}
+
+	// Only a verified email says who the user is, anyone can put an
+	// address they do not own on their account
+	user, err := admin.FindUser(r.cognitoService, r.userPoolID, "email", auth.NormalizeEmail(email))
+	if err != nil && err != admin.ErrNotFound {
+		return "", err
+	}
+	var sub string
+	if user.EmailVerified {
+		sub = user.UserID
+	}
+	r.subs[auth.NormalizeEmail(email)] = sub
+	return sub, nil
+}
+
+func attribute(attributes []*cognitoidentityprovider.AttributeType, name string) string {
+	for _, attribute := range attributes {
+		if aws.StringValue(attribute.Name) == name {
+			return aws.StringValue(attribute.Value)
+		}
+	}
+	return ""
+}
+
+// emailOwned returns every item of the table whose Owner is still an email
+// A sub never contains an @
+func emailOwned(dynamoService *dynamodb.DynamoDB, table string) ([]map[string]*dynamodb.AttributeValue, error) {
+	dynamoInput := dynamodb.ScanInput{
+		TableName:        aws.String(table),
+		FilterExpression: aws.String("contains(#O, :at)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":at": {S: aws.String("@")},
+		},
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	err := dynamoService.ScanPages(&dynamoInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s: %v", table, err)
+	}
+
+	return items, nil
+}
+
+// migrateAttribute sets the Owner of the item to the sub, the devices
+// keep the email as OwnerEmail to display
+func migrateAttribute(dynamoService *dynamodb.DynamoDB, table string, keyNames []string, item map[string]*dynamodb.AttributeValue, sub string) error {
+	key := make(map[string]*dynamodb.AttributeValue)
+	for _, keyName := range keyNames {
+		key[keyName] = item[keyName]
+	}
+
+	updateExpression := "SET #O = :s"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":s": {S: aws.String(sub)},
+		":e": item["Owner"],
+	}
+	if table == device.TableName {
+		updateExpression += ", OwnerEmail = :e"
+	}
+
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 key,
+		UpdateExpression:    aws.String(updateExpression),
+		ConditionExpression: aws.String("#O = :e"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("error updating owner in %s: %v", table, err)
+	}
+	return nil
+}
+
+// migrateKey copies the item under the sub and deletes the item keyed by
+// the email, a copy that already exists is left as it is
+func migrateKey(dynamoService *dynamodb.DynamoDB, table string, keyNames []string, item map[string]*dynamodb.AttributeValue, sub string) error {
+	migrated := make(map[string]*dynamodb.AttributeValue)
+	for attributeName, attributeValue := range item {
+		migrated[attributeName] = attributeValue
+	}
+	migrated["Owner"] = &dynamodb.AttributeValue{S: aws.String(sub)}
+
+	oldKey := make(map[string]*dynamodb.AttributeValue)
+	for _, keyName := range keyNames {
+		oldKey[keyName] = item[keyName]
+	}
+
+	_, err := dynamoService.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                migrated,
+		ConditionExpression: aws.String("attribute_not_exists(#O)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("error copying item of %s: %v", table, err)
+	}
+
+	_, err = dynamoService.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key:       oldKey,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting item of %s: %v", table, err)
+	}
+	return nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
+
+func main() {
+	userPoolID := flag.String("user-pool-id", os.Getenv("COGNITO_USER_POOL_ID"), "cognito user pool the owners are looked up in")
+	dryRun := flag.Bool("dry-run", false, "only log what would be migrated")
+	flag.Parse()
+
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if *userPoolID == "" {
+		log.Fatal("-user-pool-id not set")
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	users := &resolver{
+		cognitoService: cognitoidentityprovider.New(sess),
+		userPoolID:     *userPoolID,
+		subs:           make(map[string]string),
+	}
+
+	var migrated, skipped int
+	for _, table := range ownedTables {
+		items, err := emailOwned(dynamoService, table.name)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, item := range items {
+			email := aws.StringValue(item["Owner"].S)
+			sub, err := users.sub(email)
+			if err != nil {
+				log.Fatal(err)
+			}
+			// Items of users that no longer exist are left for account
+			// deletion, there is nothing to key them by
+			if sub == "" {
+				log.Printf("Skipping item of %s owned by unknown user %s\n", table.name, email)
+				skipped++
+				continue
+			}
+
+			if *dryRun {
+				log.Printf("Would migrate item of %s from %s to %s\n", table.name, email, sub)
+				migrated++
+				continue
+			}
+
+			if table.ownerKey {
+				err = migrateKey(dynamoService, table.name, table.keyNames, item, sub)
+			} else {
+				err = migrateAttribute(dynamoService, table.name, table.keyNames, item, sub)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			migrated++
+		}
+	}
+
+	log.Printf("Migrated %d items, skipped %d items of unknown users\n", migrated, skipped)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// Reset codes are emailed, so these keep the endpoint from being
+// used to flood someone's inbox
+var (
+	emailLimit = auth.Limit{Requests: 5, Window: time.Hour}
+	ipLimit    = auth.Limit{Requests: 20, Window: time.Hour}
+)
+
+// ForgotPasswordEvent defines the request structure of this forgot password request
+type ForgotPasswordEvent struct {
+	Email string `json:"email"`
+}
+
+// Response defines the response structure to this forgot password request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// ForgotPassword is the lambda function handler
+// it emails a password reset code to the user
+// The response is the same whether or not the email is registered
+func ForgotPassword(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt ForgotPasswordEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Email == "" {
+		resp := Response{
+			Message: "email missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	now := time.Now()
+
+	allowed, err := auth.Allow(dynamoService, "forgot:email:"+auth.NormalizeEmail(evt.Email), emailLimit, now)
+	if err == nil && allowed {
+		allowed, err = auth.Allow(dynamoService, "forgot:ip:"+req.RequestContext.Identity.SourceIP, ipLimit, now)
+	}
+	if err != nil {
+		log.Println("Error checking rate limit (dynamo):", err)
+		resp := Response{
+			Message: "Error requesting password reset",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !allowed {
+		resp := Response{
+			Message: "Too many requests, try again later",
+			Error:   "Rate limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 429}, nil
+	}
+
+	cognitoInput := cognitoidentityprovider.ForgotPasswordInput{
+		ClientId: aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		Username: aws.String(evt.Email),
+	}
+
+	_, err = cognitoService.ForgotPassword(&cognitoInput)
+	if err != nil {
+		log.Println("Error requesting password reset (cognito):", err)
+		switch auth.Code(err) {
+		// Unknown users, users without a verified email and disabled
+		// users get the same answer as everyone else
+		case cognitoidentityprovider.ErrCodeUserNotFoundException,
+			cognitoidentityprovider.ErrCodeInvalidParameterException,
+			cognitoidentityprovider.ErrCodeNotAuthorizedException:
+		default:
+			statusCode, message := auth.ErrorStatus(err)
+			resp := Response{
+				Message: message,
+				Error:   "Password Reset Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+		}
+	}
+
+	resp := Response{
+		Message: "If the email is registered a password reset code has been sent to it",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	lambda.Start(ForgotPassword)
+}
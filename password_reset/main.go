@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// These keep reset codes from being guessed
+var (
+	emailLimit = auth.Limit{Requests: 10, Window: time.Hour}
+	ipLimit    = auth.Limit{Requests: 50, Window: time.Hour}
+)
+
+// ResetPasswordEvent defines the request structure of this password reset request
+type ResetPasswordEvent struct {
+	Email string `json:"email"`
+	// Code is the reset code emailed by the forgot password request
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// Response defines the response structure to this password reset request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// ResetPassword is the lambda function handler
+// it sets a new password with the code emailed to the user
+// The response is the same whether or not the email is registered
+func ResetPassword(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt ResetPasswordEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Email == "" {
+		resp := Response{
+			Message: "email missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Code == "" {
+		resp := Response{
+			Message: "code missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Password == "" {
+		resp := Response{
+			Message: "password missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	now := time.Now()
+
+	allowed, err := auth.Allow(dynamoService, "reset:email:"+auth.NormalizeEmail(evt.Email), emailLimit, now)
+	if err == nil && allowed {
+		allowed, err = auth.Allow(dynamoService, "reset:ip:"+req.RequestContext.Identity.SourceIP, ipLimit, now)
+	}
+	if err != nil {
+		log.Println("Error checking rate limit (dynamo):", err)
+		resp := Response{
+			Message: "Error resetting password",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !allowed {
+		resp := Response{
+			Message: "Too many requests, try again later",
+			Error:   "Rate limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 429}, nil
+	}
+
+	// Checking the password first gives the user a helpful message
+	// without using up the reset code
+	policy, err := auth.LoadPasswordPolicy(cognitoService, os.Getenv("COGNITO_USER_POOL_ID"))
+	if err != nil {
+		log.Println("Error loading password policy (cognito):", err)
+		resp := Response{
+			Message: "Error resetting password",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	err = policy.Validate(evt.Password)
+	if err != nil {
+		resp := Response{
+			Message: err.Error(),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+
+	cognitoInput := cognitoidentityprovider.ConfirmForgotPasswordInput{
+		ClientId:         aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		Username:         aws.String(evt.Email),
+		ConfirmationCode: aws.String(evt.Code),
+		Password:         aws.String(evt.Password),
+	}
+
+	_, err = cognitoService.ConfirmForgotPassword(&cognitoInput)
+	if err != nil {
+		log.Println("Error resetting password (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		// Unknown users get the same answer as a wrong code
+		case cognitoidentityprovider.ErrCodeUserNotFoundException,
+			cognitoidentityprovider.ErrCodeNotAuthorizedException,
+			cognitoidentityprovider.ErrCodeCodeMismatchException,
+			cognitoidentityprovider.ErrCodeExpiredCodeException:
+			statusCode, message = 400, "Invalid or expired code provided"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Password Reset Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully reset password",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(ResetPassword)
+}
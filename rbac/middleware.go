@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+)
+
+// Handler is a lambda function handler of an API gateway endpoint
+type Handler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// ForbiddenResponse is the body of requests missing a permission
+type ForbiddenResponse struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+	// Permission is the permission the user is missing
+	Permission string `json:"Permission"`
+}
+
+// Require wraps the handler so it only runs for users with the permission
+// Requests without claims are left to the handler, which rejects them
+func Require(permission string, handler Handler) Handler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		req = decodeClaims(req)
+		claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{})
+		if ok && !Allowed(claims, permission) {
+			return Forbidden(permission), nil
+		}
+		return handler(ctx, req)
+	}
+}
+
+// decodeClaims hands the claims to the handler as the map it reads
+// The context of a lambda authorizer can only hold strings, numbers
+// and booleans, so the API authorizer passes the claims on as JSON
+func decodeClaims(req events.APIGatewayProxyRequest) events.APIGatewayProxyRequest {
+	encoded, ok := req.RequestContext.Authorizer["claims"].(string)
+	if !ok || encoded == "" {
+		return req
+	}
+
+	var claims map[string]interface{}
+	err := json.Unmarshal([]byte(encoded), &claims)
+	if err != nil {
+		log.Println("Error decoding claims:", err)
+		return req
+	}
+
+	authorizer := make(map[string]interface{}, len(req.RequestContext.Authorizer))
+	for name, value := range req.RequestContext.Authorizer {
+		authorizer[name] = value
+	}
+	authorizer["claims"] = claims
+	req.RequestContext.Authorizer = authorizer
+	return req
+}
+
+// Forbidden returns the 403 response naming the missing permission
+func Forbidden(permission string) events.APIGatewayProxyResponse {
+	resp := ForbiddenResponse{
+		Message:    "Missing permission " + permission,
+		Error:      "Not authorized",
+		Permission: permission,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}
+}
@@ -0,0 +1,193 @@
+package rbac
+
+import (
+	"strings"
+)
+
+// Permissions the handlers require
+// A permission ending in :* grants every permission of its resource
+// and * grants every permission
+const (
+	// AccountManage covers the account of the user itself, their profile,
+	// email, password, MFA, sign out, data exports and deletion
+	AccountManage     = "account:manage"
+	DeviceRead        = "device:read"
+	DeviceWrite       = "device:write"
+	WebhookRead       = "webhook:read"
+	WebhookWrite      = "webhook:write"
+	NotificationRead  = "notification:read"
+	NotificationWrite = "notification:write"
+	AlertRead         = "alert:read"
+	AlertWrite        = "alert:write"
+	ScheduleRead      = "schedule:read"
+	ScheduleWrite     = "schedule:write"
+	OrganizationRead  = "organization:read"
+	OrganizationWrite = "organization:write"
+	// AdminRead and AdminWrite cover the admin API support staff uses
+	// on the accounts and devices of any user
+	AdminRead  = "admin:read"
+	AdminWrite = "admin:write"
+	AdminAll   = "admin:*"
+)
+
+// Permissions lists every permission a handler requires
+var Permissions = []string{
+	AccountManage,
+	DeviceRead,
+	DeviceWrite,
+	WebhookRead,
+	WebhookWrite,
+	NotificationRead,
+	NotificationWrite,
+	AlertRead,
+	AlertWrite,
+	ScheduleRead,
+	ScheduleWrite,
+	OrganizationRead,
+	OrganizationWrite,
+	AdminRead,
+	AdminWrite,
+}
+
+// Roles a user can have
+const (
+	RoleAdmin  = "admin"
+	RoleUser   = "user"
+	RoleViewer = "viewer"
+)
+
+// DefaultRole is the role of users that are not in any group with a role
+const DefaultRole = RoleUser
+
+// RolePermissions are the permissions every role is granted
+var RolePermissions = map[string][]string{
+	RoleAdmin: {"*"},
+	RoleUser: {
+		"account:*",
+		"device:*",
+		"webhook:*",
+		"notification:*",
+		"alert:*",
+		"schedule:*",
+		"organization:*",
+	},
+	RoleViewer: {
+		"account:*",
+		DeviceRead,
+		WebhookRead,
+		NotificationRead,
+		AlertRead,
+		ScheduleRead,
+		OrganizationRead,
+	},
+}
+
+// GroupRoles maps the cognito groups to the role their members have
+// Groups missing from it grant nothing
+var GroupRoles = map[string]string{
+	"admins":  RoleAdmin,
+	"users":   RoleUser,
+	"viewers": RoleViewer,
+}
+
+// GroupsClaim is the claim of the id token listing the cognito groups of the user
+const GroupsClaim = "cognito:groups"
+
+// ScopesClaim is the claim listing the scopes of an API key
+// Requests made with an API key only have the permissions both the
+// roles of the user and the scopes of the key grant
+const ScopesClaim = "hermes:scopes"
+
+// Groups returns the cognito groups of the claims
+func Groups(claims map[string]interface{}) []string {
+	return list(claims[GroupsClaim])
+}
+
+// Scopes returns the scopes of the claims and whether there are any,
+// requests made with a cognito token have none
+func Scopes(claims map[string]interface{}) ([]string, bool) {
+	if _, ok := claims[ScopesClaim]; !ok {
+		return nil, false
+	}
+	return list(claims[ScopesClaim]), true
+}
+
+// ValidScope reports whether the scope grants any permission
+func ValidScope(scope string) bool {
+	for _, permission := range Permissions {
+		if grants(scope, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles returns the roles of a user in the groups
+func Roles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		if role, ok := GroupRoles[group]; ok {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		roles = append(roles, DefaultRole)
+	}
+	return roles
+}
+
+// Allowed reports whether the user the claims belong to has the permission
+func Allowed(claims map[string]interface{}, permission string) bool {
+	if scopes, ok := Scopes(claims); ok && !grantsAny(scopes, permission) {
+		return false
+	}
+	for _, role := range Roles(Groups(claims)) {
+		if grantsAny(RolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func grantsAny(granted []string, permission string) bool {
+	for _, g := range granted {
+		if grants(g, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func grants(granted string, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+	if strings.HasSuffix(granted, ":*") {
+		return strings.HasPrefix(permission, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+// list returns the strings of a claim holding a list
+// Verified tokens have lists as JSON arrays, the cognito authorizer of
+// API gateway passes them on as a single string like "[admins users]"
+// or "admins,users"
+func list(claim interface{}) []string {
+	var values []string
+	switch value := claim.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	case []string:
+		values = value
+	case string:
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		values = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return values
+}
@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression
+// minute hour day-of-month month day-of-week
+type Cron struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// Standard cron matches either day field when both are restricted
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// maxSearch bounds the search for the next run, an expression like
+// "0 0 30 2 *" never matches
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// ErrNoNextRun is returned when an expression has no run within five years
+var ErrNoNextRun = errors.New("cron expression never matches")
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var weekdayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// ParseCron parses an expression like "30 2 * * MON-FRI"
+// Fields accept *, numbers, names of months and weekdays, lists,
+// ranges and steps, Sunday is both 0 and 7
+func ParseCron(expression string) (Cron, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Cron{}, errors.New("cron expression needs 5 fields")
+	}
+
+	var c Cron
+	var err error
+	if c.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return Cron{}, fmt.Errorf("minute: %v", err)
+	}
+	if c.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return Cron{}, fmt.Errorf("hour: %v", err)
+	}
+	if c.days, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return Cron{}, fmt.Errorf("day of month: %v", err)
+	}
+	if c.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return Cron{}, fmt.Errorf("month: %v", err)
+	}
+	if c.weekdays, err = parseField(fields[4], 0, 7, weekdayNames); err != nil {
+		return Cron{}, fmt.Errorf("day of week: %v", err)
+	}
+	// Sunday is 0 as well as 7
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+	c.daysRestricted = fields[2] != "*"
+	c.weekdaysRestricted = fields[4] != "*"
+
+	return c, nil
+}
+
+// Next returns the first time after t the expression matches,
+// evaluated in the location of t
+// Times falling in a daylight saving gap are skipped, times repeated
+// when clocks go back only match once
+func (c Cron) Next(t time.Time) (time.Time, error) {
+	previous := t.Format("2006-01-02T15:04")
+	// Runs are on whole minutes
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+
+	for t.Before(limit) {
+		if c.months&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if c.hours&(1<<uint(t.Hour())) == 0 {
+			// Adding minutes rather than using time.Date keeps
+			// daylight saving gaps from sending t backwards
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if c.minutes&(1<<uint(t.Minute())) == 0 || t.Format("2006-01-02T15:04") == previous {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, nil
+	}
+
+	return time.Time{}, ErrNoNextRun
+}
+
+// forward returns next, unless a daylight saving gap made time.Date
+// normalize it to before t, in which case t moves on by an hour
+func forward(t time.Time, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.daysRestricted && c.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// parseField returns the allowed values of a field as a bit set
+func parseField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %s", part)
+			}
+		default:
+			value, err := parseValue(part, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			// A single value with a step, like 5/15, runs to the maximum
+			if step == 1 {
+				high = value
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
+
+func parseValue(raw string, min int, max int, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToUpper(raw)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("%s is not between %d and %d", raw, min, max)
+	}
+	return value, nil
+}
@@ -0,0 +1,117 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"time"
+)
+
+// Dispatch runs every schedule that is due at now
+// Each schedule is claimed before it runs and every run, successful
+// or not, is recorded in the run history of the schedule
+func Dispatch(dynamoService *dynamodb.DynamoDB, now time.Time) error {
+	schedules, err := DueSchedules(dynamoService, now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		// Runs missed while the dispatcher was not running are not
+		// caught up on, the schedule moves on to its next run after now
+		var nextRun string
+		next, err := schedule.NextRunAfter(now)
+		if err == nil {
+			nextRun = next.UTC().Format(TimestampFormat)
+		} else if err != ErrNoNextRun {
+			// Claiming without a next run would end the schedule, it
+			// is left as it is and retried on the next dispatch instead
+			log.Printf("Error computing next run of schedule %s: %v\n", schedule.ScheduleID, err)
+			continue
+		}
+
+		err = Claim(dynamoService, schedule, nextRun, now)
+		if err == ErrConflict {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		run := Run{
+			ScheduleID:   schedule.ScheduleID,
+			RunAt:        now.UTC().Format(TimestampFormat),
+			ScheduledFor: schedule.NextRun,
+			Status:       RunSucceeded,
+		}
+		err = execute(dynamoService, schedule, now)
+		if err != nil {
+			log.Printf("Error running schedule %s: %v\n", schedule.ScheduleID, err)
+			run.Status = RunFailed
+			run.Error = err.Error()
+		}
+
+		err = PutRun(dynamoService, run)
+		if err != nil {
+			// The action was applied at this point, a missing
+			// history entry is not worth failing the others for
+			log.Println("Error recording schedule run:", err)
+		}
+	}
+
+	return nil
+}
+
+// execute applies the action of the schedule to its device
+func execute(dynamoService *dynamodb.DynamoDB, schedule Schedule, now time.Time) error {
+	dynamoGetInput := dynamodb.GetItemInput{
+		TableName: aws.String(device.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(schedule.MAC)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoGetInput)
+	if err != nil {
+		return fmt.Errorf("error looking up device %s: %v", schedule.MAC, err)
+	}
+
+	// The device may have been deleted or given away since the
+	// schedule was created, or its owner left the organization
+	if len(dynamoResponse.Item) == 0 {
+		return fmt.Errorf("device %s not found", schedule.MAC)
+	}
+	authorized, err := org.Authorize(dynamoService, schedule.Owner, stringValue(dynamoResponse.Item["Owner"]), org.RoleOperator)
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return errors.New("schedule owner can no longer operate the device")
+	}
+
+	err = schedule.Action.Validate()
+	if err != nil {
+		return err
+	}
+
+	previousItem, updatedItem, err := device.Apply(dynamoService, schedule.MAC, schedule.Action)
+	if err != nil {
+		return err
+	}
+
+	auditEntry := audit.Entry{
+		MAC:        schedule.MAC,
+		Timestamp:  now.UTC().Format(audit.TimestampFormat),
+		Action:     audit.ActionUpdateDevice,
+		ActorID:    "schedule:" + schedule.ScheduleID,
+		ActorEmail: stringValue(dynamoResponse.Item["OwnerEmail"]),
+		Changes:    audit.Diff(previousItem, updatedItem),
+	}
+	return audit.Record(dynamoService, auditEntry)
+}
@@ -0,0 +1,384 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Dynamo tables backing the schedules
+const (
+	// SchedulesTable hash key is Owner, range key is ScheduleID
+	SchedulesTable = "device_schedules"
+	// RunsTable hash key is ScheduleID, range key is RunAt
+	RunsTable = "schedule_runs"
+	// DueIndex is a sparse index of SchedulesTable
+	// Hash key is Due, range key is NextRun
+	DueIndex = "DueIndex"
+)
+
+// MaxSchedules is the number of schedules a user can have
+const MaxSchedules = 25
+
+// Run statuses
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// TimestampFormat is fixed width so that runs sort
+// lexicographically in the order they happened
+const TimestampFormat = "2006-01-02T15:04:05.000000Z"
+
+// dueMarker is the hash key of every schedule with a next run
+const dueMarker = "due"
+
+// runRetention is how long the run history is kept
+const runRetention = 90 * 24 * time.Hour
+
+// ErrNotFound is returned when a schedule does not exist
+var ErrNotFound = errors.New("schedule not found")
+
+// ErrConflict is returned when another dispatcher claimed the run
+var ErrConflict = errors.New("schedule run already claimed")
+
+// Schedule applies Action to a device every time Cron matches in TimeZone
+type Schedule struct {
+	ScheduleID string `json:"id"`
+	Owner      string `json:"owner"`
+	MAC        string `json:"mac"`
+	Name       string `json:"name"`
+	Cron       string `json:"cron"`
+	// TimeZone is an IANA time zone name like Europe/Berlin
+	TimeZone string        `json:"timeZone"`
+	Action   device.Update `json:"action"`
+	// NextRun is empty once the expression no longer matches
+	NextRun   string `json:"nextRun,omitempty"`
+	LastRun   string `json:"lastRun,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Run is an entry of the run history of a schedule
+type Run struct {
+	ScheduleID string `json:"scheduleID"`
+	RunAt      string `json:"runAt"`
+	// ScheduledFor is the run time the cron expression gave
+	ScheduledFor string `json:"scheduledFor"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Validate returns an error describing the first invalid field
+// The message is meant to be returned to the user as is
+func (s Schedule) Validate() error {
+	if !device.ValidMAC(s.MAC) {
+		return fmt.Errorf("Invalid MAC Address Provided: %s", s.MAC)
+	}
+	if utf8.RuneCountInString(s.Name) > 50 {
+		return errors.New("Provided name too long")
+	}
+	_, err := ParseCron(s.Cron)
+	if err != nil {
+		return fmt.Errorf("Invalid cron expression provided: %s", err)
+	}
+	_, err = time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return fmt.Errorf("Invalid time zone provided: %s", s.TimeZone)
+	}
+	// The action goes through the same validation as UpdateDevice
+	err = s.Action.Validate()
+	if err != nil {
+		return fmt.Errorf("Invalid action provided: %s", err)
+	}
+	return nil
+}
+
+// NextRunAfter returns the first run of the schedule after t
+func (s Schedule) NextRunAfter(t time.Time) (time.Time, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cron.Next(t.In(location))
+}
+
+// PutSchedule stores a new schedule
+func PutSchedule(dynamoService *dynamodb.DynamoDB, schedule Schedule) error {
+	action, err := json.Marshal(schedule.Action)
+	if err != nil {
+		return err
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		"Owner":      {S: aws.String(schedule.Owner)},
+		"ScheduleID": {S: aws.String(schedule.ScheduleID)},
+		"MAC":        {S: aws.String(schedule.MAC)},
+		"Cron":       {S: aws.String(schedule.Cron)},
+		"TimeZone":   {S: aws.String(schedule.TimeZone)},
+		"Action":     {S: aws.String(string(action))},
+		"CreatedAt":  {S: aws.String(schedule.CreatedAt)},
+	}
+	if schedule.Name != "" {
+		item["Name"] = &dynamodb.AttributeValue{S: aws.String(schedule.Name)}
+	}
+	if schedule.NextRun != "" {
+		item["NextRun"] = &dynamodb.AttributeValue{S: aws.String(schedule.NextRun)}
+		item["Due"] = &dynamodb.AttributeValue{S: aws.String(dueMarker)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName:           aws.String(SchedulesTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ScheduleID)"),
+	}
+
+	_, err = dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing schedule %s: %v", schedule.ScheduleID, err)
+	}
+	return nil
+}
+
+// GetSchedule returns the schedule or ErrNotFound
+func GetSchedule(dynamoService *dynamodb.DynamoDB, owner string, scheduleID string) (Schedule, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(SchedulesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":      {S: aws.String(owner)},
+			"ScheduleID": {S: aws.String(scheduleID)},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("error looking up schedule %s: %v", scheduleID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Schedule{}, ErrNotFound
+	}
+
+	return scheduleFromItem(dynamoResponse.Item), nil
+}
+
+// ListSchedules returns every schedule of the owner
+func ListSchedules(dynamoService *dynamodb.DynamoDB, owner string) ([]Schedule, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(SchedulesTable),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	schedules := []Schedule{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			schedules = append(schedules, scheduleFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing schedules of %s: %v", owner, err)
+	}
+
+	return schedules, nil
+}
+
+// DeleteSchedule removes the schedule or returns ErrNotFound
+// The run history expires on its own
+func DeleteSchedule(dynamoService *dynamodb.DynamoDB, owner string, scheduleID string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(SchedulesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":      {S: aws.String(owner)},
+			"ScheduleID": {S: aws.String(scheduleID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(ScheduleID)"),
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting schedule %s: %v", scheduleID, err)
+	}
+	return nil
+}
+
+// DueSchedules returns the schedules whose next run is due at now
+// The sparse index only holds schedules that have a next run, so
+// this does not scan the schedules table
+func DueSchedules(dynamoService *dynamodb.DynamoDB, now time.Time) ([]Schedule, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(SchedulesTable),
+		IndexName:              aws.String(DueIndex),
+		KeyConditionExpression: aws.String("Due = :d AND NextRun <= :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":d": {S: aws.String(dueMarker)},
+			":n": {S: aws.String(now.UTC().Format(TimestampFormat))},
+		},
+	}
+
+	var schedules []Schedule
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			schedules = append(schedules, scheduleFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing due schedules: %v", err)
+	}
+
+	return schedules, nil
+}
+
+// Claim moves the schedule on to its next run, nextRun being empty when
+// there is none, and returns ErrConflict if another dispatcher already did
+// Claiming before running keeps overlapping dispatchers from running a
+// schedule twice
+func Claim(dynamoService *dynamodb.DynamoDB, schedule Schedule, nextRun string, now time.Time) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(SchedulesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":      {S: aws.String(schedule.Owner)},
+			"ScheduleID": {S: aws.String(schedule.ScheduleID)},
+		},
+		ConditionExpression: aws.String("NextRun = :c"),
+		UpdateExpression:    aws.String("SET NextRun = :n, LastRun = :l"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(schedule.NextRun)},
+			":n": {S: aws.String(nextRun)},
+			":l": {S: aws.String(now.UTC().Format(TimestampFormat))},
+		},
+	}
+	if nextRun == "" {
+		dynamoInput.UpdateExpression = aws.String("SET LastRun = :l REMOVE NextRun, Due")
+		delete(dynamoInput.ExpressionAttributeValues, ":n")
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("error claiming schedule %s: %v", schedule.ScheduleID, err)
+	}
+	return nil
+}
+
+// PutRun records a run in the run history
+func PutRun(dynamoService *dynamodb.DynamoDB, run Run) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"ScheduleID":   {S: aws.String(run.ScheduleID)},
+		"RunAt":        {S: aws.String(run.RunAt)},
+		"ScheduledFor": {S: aws.String(run.ScheduledFor)},
+		"Status":       {S: aws.String(run.Status)},
+		"ExpiresAt":    {N: aws.String(strconv.FormatInt(time.Now().Add(runRetention).Unix(), 10))},
+	}
+	if run.Error != "" {
+		item["Error"] = &dynamodb.AttributeValue{S: aws.String(run.Error)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(RunsTable),
+		Item:      item,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing run of schedule %s: %v", run.ScheduleID, err)
+	}
+	return nil
+}
+
+// ListRuns returns the run history of a schedule, newest first
+// startRunAt continues a previous listing, the returned string
+// is the run to continue from or empty if there are no more
+func ListRuns(dynamoService *dynamodb.DynamoDB, scheduleID string, limit int64, startRunAt string) ([]Run, string, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(RunsTable),
+		KeyConditionExpression: aws.String("ScheduleID = :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {S: aws.String(scheduleID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(limit),
+	}
+
+	if startRunAt != "" {
+		dynamoInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"ScheduleID": {S: aws.String(scheduleID)},
+			"RunAt":      {S: aws.String(startRunAt)},
+		}
+	}
+
+	dynamoResponse, err := dynamoService.Query(&dynamoInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("error querying runs of schedule %s: %v", scheduleID, err)
+	}
+
+	runs := make([]Run, 0, len(dynamoResponse.Items))
+	for _, item := range dynamoResponse.Items {
+		runs = append(runs, Run{
+			ScheduleID:   stringValue(item["ScheduleID"]),
+			RunAt:        stringValue(item["RunAt"]),
+			ScheduledFor: stringValue(item["ScheduledFor"]),
+			Status:       stringValue(item["Status"]),
+			Error:        stringValue(item["Error"]),
+		})
+	}
+
+	var next string
+	if dynamoResponse.LastEvaluatedKey != nil {
+		next = stringValue(dynamoResponse.LastEvaluatedKey["RunAt"])
+	}
+
+	return runs, next, nil
+}
+
+func scheduleFromItem(item map[string]*dynamodb.AttributeValue) Schedule {
+	schedule := Schedule{
+		ScheduleID: stringValue(item["ScheduleID"]),
+		Owner:      stringValue(item["Owner"]),
+		MAC:        stringValue(item["MAC"]),
+		Name:       stringValue(item["Name"]),
+		Cron:       stringValue(item["Cron"]),
+		TimeZone:   stringValue(item["TimeZone"]),
+		NextRun:    stringValue(item["NextRun"]),
+		LastRun:    stringValue(item["LastRun"]),
+		CreatedAt:  stringValue(item["CreatedAt"]),
+	}
+	// A schedule with an unreadable action fails validation when it runs
+	json.Unmarshal([]byte(stringValue(item["Action"])), &schedule.Action)
+	return schedule
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/schedule"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// ScheduleCreateEvent defines the request structure of this schedule creation request
+type ScheduleCreateEvent struct {
+	MAC  string `json:"mac"`
+	Name string `json:"name"`
+	// Cron is a five field cron expression like "0 22 * * *"
+	Cron string `json:"cron"`
+	// TimeZone defaults to UTC
+	TimeZone string `json:"timeZone"`
+	// Action takes the same fields as a device update
+	Action device.Update `json:"action"`
+}
+
+// Response defines the response structure to this schedule creation request
+type Response struct {
+	Message  string             `json:"Response"`
+	Error    string             `json:"Error"`
+	Schedule *schedule.Schedule `json:"Schedule,omitempty"`
+}
+
+// CreateSchedule is the lambda function handler
+func CreateSchedule(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt ScheduleCreateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.TimeZone == "" {
+		evt.TimeZone = "UTC"
+	}
+
+	newSchedule := schedule.Schedule{
+		ScheduleID: webhook.NewID(),
+		MAC:        evt.MAC,
+		Name:       evt.Name,
+		Cron:       evt.Cron,
+		TimeZone:   evt.TimeZone,
+		Action:     evt.Action,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	err = newSchedule.Validate()
+	if err != nil {
+		resp := Response{
+			Message: err.Error(),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	nextRun, err := newSchedule.NextRunAfter(time.Now())
+	if err != nil {
+		resp := Response{
+			Message: fmt.Sprintf("Cron expression has no upcoming run: %s", evt.Cron),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+	newSchedule.NextRun = nextRun.UTC().Format(schedule.TimestampFormat)
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	newSchedule.Owner = subFromToken
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	dynamoGetInput := dynamodb.GetItemInput{
+		TableName: aws.String(device.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"MAC": {S: aws.String(evt.MAC)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoGetInput)
+	if err != nil {
+		log.Println("Error looking up device (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up MAC",
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(dynamoResponse.Item) == 0 {
+		resp := Response{
+			Message: fmt.Sprintf("MAC not found: %s", evt.MAC),
+			Error:   "MAC lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+
+	// The device is owned by the user or by an organization the
+	// user operates devices of
+	authorized, err := org.Authorize(dynamoService, subFromToken, aws.StringValue(dynamoResponse.Item["Owner"].S), org.RoleOperator)
+	if err != nil {
+		log.Println("Error looking up membership (dynamo)", err)
+		resp := Response{
+			Message: "Error creating schedule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if !authorized {
+		resp := Response{
+			Message: "Not authorized to perform this action",
+			Error:   "Not authorized",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	existing, err := schedule.ListSchedules(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing schedules (dynamo)", err)
+		resp := Response{
+			Message: "Error creating schedule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(existing) >= schedule.MaxSchedules {
+		resp := Response{
+			Message: fmt.Sprintf("A user can have at most %d schedules", schedule.MaxSchedules),
+			Error:   "Limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	err = schedule.PutSchedule(dynamoService, newSchedule)
+	if err != nil {
+		log.Println("Error creating schedule (dynamo)", err)
+		resp := Response{
+			Message: "Error creating schedule",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message:  fmt.Sprintf("Successfully created schedule %s, next run at %s", newSchedule.ScheduleID, newSchedule.NextRun),
+		Schedule: &newSchedule,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.ScheduleWrite, CreateSchedule))
+}
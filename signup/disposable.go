@@ -0,0 +1,46 @@
+package signup
+
+// disposableDomains are domains of disposable email services
+// The list covers the common services, not every one of them
+var disposableDomains = []string{
+	"10minutemail.com",
+	"20minutemail.com",
+	"33mail.com",
+	"discard.email",
+	"dispostable.com",
+	"emailondeck.com",
+	"fakeinbox.com",
+	"getairmail.com",
+	"getnada.com",
+	"guerrillamail.biz",
+	"guerrillamail.com",
+	"guerrillamail.de",
+	"guerrillamail.info",
+	"guerrillamail.net",
+	"guerrillamail.org",
+	"guerrillamailblock.com",
+	"harakirimail.com",
+	"inboxkitten.com",
+	"mailcatch.com",
+	"maildrop.cc",
+	"mailinator.com",
+	"mailinator.net",
+	"mailnesia.com",
+	"mintemail.com",
+	"mohmal.com",
+	"mytemp.email",
+	"sharklasers.com",
+	"spam4.me",
+	"spamgourmet.com",
+	"temp-mail.org",
+	"tempail.com",
+	"tempmail.net",
+	"tempmailo.com",
+	"tempr.email",
+	"throwawaymail.com",
+	"trashmail.com",
+	"trashmail.de",
+	"yopmail.com",
+	"yopmail.fr",
+	"yopmail.net",
+}
@@ -0,0 +1,145 @@
+package signup
+
+import (
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"os"
+	"strings"
+)
+
+// InvitesTable hash key is Email, the normalized email of the invited user
+const InvitesTable = "registration_invites"
+
+// rejectionPrefix marks the errors of the trigger that are rejections
+// rather than failures, cognito passes the message on to the sign up
+const rejectionPrefix = "Registration rejected: "
+
+// Rejection is a sign up refused by the policy
+// Message is meant to be returned to the user as is
+type Rejection struct {
+	Message string
+}
+
+func (r Rejection) Error() string {
+	return rejectionPrefix + r.Message
+}
+
+// Policy decides who can register
+type Policy struct {
+	// AllowedDomains are the only email domains that can register
+	// when set, subdomains included
+	AllowedDomains []string
+	// DeniedDomains can never register, subdomains included
+	DeniedDomains []string
+	// BlockDisposable refuses the domains of disposable email services
+	BlockDisposable bool
+	// InviteOnly only lets emails in the invites table register
+	InviteOnly bool
+}
+
+// PolicyFromEnv reads the policy from the environment of the trigger
+// Domain lists are comma separated, the switches are enabled by "true"
+func PolicyFromEnv() Policy {
+	return Policy{
+		AllowedDomains:  domainList(os.Getenv("SIGNUP_ALLOWED_DOMAINS")),
+		DeniedDomains:   domainList(os.Getenv("SIGNUP_DENIED_DOMAINS")),
+		BlockDisposable: os.Getenv("SIGNUP_BLOCK_DISPOSABLE") == "true",
+		InviteOnly:      os.Getenv("SIGNUP_INVITE_ONLY") == "true",
+	}
+}
+
+// CheckDomain returns a Rejection when the domain of the email
+// may not register
+func (p Policy) CheckDomain(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return Rejection{Message: "Invalid email address"}
+	}
+	domain := auth.NormalizeEmail(email[at+1:])
+
+	if len(p.AllowedDomains) > 0 && !matchesDomain(domain, p.AllowedDomains) {
+		return Rejection{Message: fmt.Sprintf("Emails of %s can not register", domain)}
+	}
+	if matchesDomain(domain, p.DeniedDomains) {
+		return Rejection{Message: fmt.Sprintf("Emails of %s can not register", domain)}
+	}
+	if p.BlockDisposable && matchesDomain(domain, disposableDomains) {
+		return Rejection{Message: "Disposable email addresses can not register"}
+	}
+	return nil
+}
+
+// Check returns a Rejection when the email may not register
+func (p Policy) Check(dynamoService *dynamodb.DynamoDB, email string) error {
+	err := p.CheckDomain(email)
+	if err != nil {
+		return err
+	}
+
+	if p.InviteOnly {
+		invited, err := Invited(dynamoService, email)
+		if err != nil {
+			return err
+		}
+		if !invited {
+			return Rejection{Message: "Registration is by invitation only"}
+		}
+	}
+	return nil
+}
+
+// Invited reports whether the email was invited to register
+func Invited(dynamoService *dynamodb.DynamoDB, email string) (bool, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(InvitesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Email": {S: aws.String(auth.NormalizeEmail(email))},
+		},
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return false, fmt.Errorf("error looking up invite of %s: %v", email, err)
+	}
+	return len(dynamoResponse.Item) > 0, nil
+}
+
+// RejectionMessage returns the message of a sign up the trigger rejected
+// Cognito reports it as "PreSignUp failed with error <error>."
+func RejectionMessage(err error) (string, bool) {
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != cognitoidentityprovider.ErrCodeUserLambdaValidationException {
+		return "", false
+	}
+
+	message := aerr.Message()
+	start := strings.Index(message, rejectionPrefix)
+	if start == -1 {
+		return "", false
+	}
+	return strings.TrimSuffix(message[start+len(rejectionPrefix):], "."), true
+}
+
+func matchesDomain(domain string, domains []string) bool {
+	for _, listed := range domains {
+		if domain == listed || strings.HasSuffix(domain, "."+listed) {
+			return true
+		}
+	}
+	return false
+}
+
+func domainList(value string) []string {
+	var domains []string
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.TrimPrefix(auth.NormalizeEmail(domain), "@")
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
@@ -0,0 +1,98 @@
+package signup
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"reflect"
+	"testing"
+)
+
+func TestCheckDomain(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   Policy
+		email    string
+		rejected bool
+	}{
+		{"no policy", Policy{}, "user@example.com", false},
+		{"no at sign", Policy{}, "user.example.com", true},
+		{"allowed", Policy{AllowedDomains: []string{"example.com"}}, "user@example.com", false},
+		{"allowed subdomain", Policy{AllowedDomains: []string{"example.com"}}, "user@mail.example.com", false},
+		{"allowed case", Policy{AllowedDomains: []string{"example.com"}}, "User@EXAMPLE.com", false},
+		{"not allowed", Policy{AllowedDomains: []string{"example.com"}}, "user@example.org", true},
+		{"not allowed suffix", Policy{AllowedDomains: []string{"example.com"}}, "user@badexample.com", true},
+		{"denied", Policy{DeniedDomains: []string{"example.org"}}, "user@example.org", true},
+		{"denied subdomain", Policy{DeniedDomains: []string{"example.org"}}, "user@mail.example.org", true},
+		{"not denied", Policy{DeniedDomains: []string{"example.org"}}, "user@example.com", false},
+		{"allowed but denied", Policy{AllowedDomains: []string{"example.com"}, DeniedDomains: []string{"mail.example.com"}}, "user@mail.example.com", true},
+		{"disposable", Policy{BlockDisposable: true}, "user@mailinator.com", true},
+		{"disposable subdomain", Policy{BlockDisposable: true}, "user@eu.yopmail.com", true},
+		{"disposable not blocked", Policy{}, "user@mailinator.com", false},
+		{"not disposable", Policy{BlockDisposable: true}, "user@example.com", false},
+	}
+
+	for _, c := range cases {
+		err := c.policy.CheckDomain(c.email)
+		if c.rejected {
+			if _, ok := err.(Rejection); !ok {
+				t.Errorf("%s: CheckDomain(%q) = %v, want a Rejection", c.name, c.email, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: CheckDomain(%q) = %v, want nil", c.name, c.email, err)
+		}
+	}
+}
+
+func TestDomainList(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"example.com", []string{"example.com"}},
+		{"Example.com, @example.org,,  ", []string{"example.com", "example.org"}},
+	}
+
+	for _, c := range cases {
+		if got := domainList(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("domainList(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestRejectionMessage(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		message  string
+		rejected bool
+	}{
+		{
+			"rejection",
+			awserr.New(cognitoidentityprovider.ErrCodeUserLambdaValidationException, "PreSignUp failed with error Registration rejected: Registration is by invitation only.", nil),
+			"Registration is by invitation only",
+			true,
+		},
+		{
+			"trigger failure",
+			awserr.New(cognitoidentityprovider.ErrCodeUserLambdaValidationException, "PreSignUp failed with error error looking up invite.", nil),
+			"",
+			false,
+		},
+		{
+			"other cognito error",
+			awserr.New(cognitoidentityprovider.ErrCodeUsernameExistsException, "Registration rejected: user exists", nil),
+			"",
+			false,
+		},
+		{"not a cognito error", errors.New("Registration rejected: nope"), "", false},
+	}
+
+	for _, c := range cases {
+		message, rejected := RejectionMessage(c.err)
+		if message != c.message || rejected != c.rejected {
+			t.Errorf("%s: RejectionMessage = %q, %v, want %q, %v", c.name, message, rejected, c.message, c.rejected)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/signup"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// CheckSignUp is the lambda function handler
+// it is the PreSignUp trigger of the user pool and rejects sign ups
+// the registration policy does not allow
+func CheckSignUp(ctx context.Context, evt events.CognitoEventUserPoolsPreSignup) (events.CognitoEventUserPoolsPreSignup, error) {
+	email := evt.Request.UserAttributes["email"]
+	if email == "" {
+		// Users register with their email as username
+		email = evt.UserName
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	err := signup.PolicyFromEnv().Check(dynamoService, email)
+	if rejection, ok := err.(signup.Rejection); ok {
+		log.Printf("Rejected sign up of %s: %s\n", email, rejection.Message)
+		return evt, rejection
+	}
+	if err != nil {
+		log.Println("Error checking sign up (dynamo):", err)
+		// Cognito shows the error to whoever signs up
+		return evt, errors.New("Error checking registration")
+	}
+
+	return evt, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(CheckSignUp)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+	"time"
+)
+
+// TokenGenEvent defines the request structure of this token creation request
+// Users with MFA enabled send the email and password, then the email
+// again with the session of the challenge and the code of their authenticator
+type TokenGenEvent struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Session  string `json:"session"`
+	Code     string `json:"code"`
+}
+
+// Response defines the response structure to this token creation request
+type Response struct {
+	Message string       `json:"Response"`
+	Error   string       `json:"Error"`
+	Tokens  *auth.Tokens `json:"Tokens,omitempty"`
+	// Challenge is set instead of Tokens when an MFA code is required
+	Challenge string `json:"Challenge,omitempty"`
+	// Session is passed back along with the MFA code
+	Session string `json:"Session,omitempty"`
+}
+
+// CreateToken is the lambda function handler
+// it logs the user in and returns their cognito tokens
+func CreateToken(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt TokenGenEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Email == "" {
+		resp := Response{
+			Message: "email missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Session != "" && evt.Code == "" {
+		resp := Response{
+			Message: "code missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Session == "" && evt.Password == "" {
+		resp := Response{
+			Message: "password missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	var result *cognitoidentityprovider.AuthenticationResultType
+	var challengeName, challengeSession string
+
+	if evt.Session == "" {
+		cognitoInput := cognitoidentityprovider.AdminInitiateAuthInput{
+			ClientId: aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+			AuthFlow: aws.String("ADMIN_NO_SRP_AUTH"),
+			AuthParameters: map[string]*string{
+				"USERNAME": aws.String(evt.Email),
+				"PASSWORD": aws.String(evt.Password),
+			},
+			UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+		}
+
+		cognitoResponse, err := cognitoService.AdminInitiateAuth(&cognitoInput)
+		if err != nil {
+			log.Println("Error creating token (cognito):", err)
+			statusCode, message := auth.ErrorStatus(err)
+			resp := Response{
+				Message: message,
+				Error:   "Token Creation Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: statusCode,
+			}, nil
+		}
+		result = cognitoResponse.AuthenticationResult
+		challengeName = aws.StringValue(cognitoResponse.ChallengeName)
+		challengeSession = aws.StringValue(cognitoResponse.Session)
+	} else {
+		cognitoInput := cognitoidentityprovider.AdminRespondToAuthChallengeInput{
+			ClientId:      aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+			ChallengeName: aws.String(cognitoidentityprovider.ChallengeNameTypeSoftwareTokenMfa),
+			ChallengeResponses: map[string]*string{
+				"USERNAME":                aws.String(evt.Email),
+				"SOFTWARE_TOKEN_MFA_CODE": aws.String(evt.Code),
+			},
+			Session:    aws.String(evt.Session),
+			UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+		}
+
+		cognitoResponse, err := cognitoService.AdminRespondToAuthChallenge(&cognitoInput)
+		if err != nil {
+			log.Println("Error responding to MFA challenge (cognito):", err)
+			statusCode, message := auth.ErrorStatus(err)
+			// Sessions are only valid for a few minutes
+			if statusCode == 401 {
+				message = "Session is invalid or expired, log in again"
+			}
+			resp := Response{
+				Message: message,
+				Error:   "Token Creation Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: statusCode,
+			}, nil
+		}
+		result = cognitoResponse.AuthenticationResult
+		challengeName = aws.StringValue(cognitoResponse.ChallengeName)
+		challengeSession = aws.StringValue(cognitoResponse.Session)
+	}
+
+	// The client answers the MFA challenge with another request
+	if result == nil && challengeName == cognitoidentityprovider.ChallengeNameTypeSoftwareTokenMfa {
+		resp := Response{
+			Message:   "MFA code required",
+			Challenge: challengeName,
+			Session:   challengeSession,
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+	}
+
+	// Any other challenge is not something this endpoint can handle
+	if result == nil {
+		log.Println("Unhandled cognito challenge:", challengeName)
+		resp := Response{
+			Message: "Additional authentication required",
+			Error:   "Token Creation Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 403,
+		}, nil
+	}
+
+	tokens := auth.NewTokens(result, time.Now())
+
+	resp := Response{
+		Message: "Successfully created token",
+		Tokens:  &tokens,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(CreateToken)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// TokenRefreshEvent defines the request structure of this token refresh request
+type TokenRefreshEvent struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Response defines the response structure to this token refresh request
+type Response struct {
+	Message string       `json:"Response"`
+	Error   string       `json:"Error"`
+	Tokens  *auth.Tokens `json:"Tokens,omitempty"`
+}
+
+// RefreshToken is the lambda function handler
+// it exchanges a refresh token for new id and access tokens
+func RefreshToken(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt TokenRefreshEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.RefreshToken == "" {
+		resp := Response{
+			Message: "refreshToken missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.AdminInitiateAuthInput{
+		ClientId: aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		AuthFlow: aws.String("REFRESH_TOKEN_AUTH"),
+		AuthParameters: map[string]*string{
+			"REFRESH_TOKEN": aws.String(evt.RefreshToken),
+		},
+		UserPoolId: aws.String(os.Getenv("COGNITO_USER_POOL_ID")),
+	}
+
+	cognitoResponse, err := cognitoService.AdminInitiateAuth(&cognitoInput)
+	if err != nil {
+		log.Println("Error refreshing token (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		// Expired, revoked and malformed refresh tokens are all
+		// reported by cognito as NotAuthorizedException
+		if statusCode == 401 {
+			message = "Refresh token is invalid, expired or revoked"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Token Refresh Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: statusCode,
+		}, nil
+	}
+
+	// Cognito answers with a challenge instead of tokens when the
+	// user has to do more than present the refresh token
+	if cognitoResponse.AuthenticationResult == nil {
+		log.Println("Refreshing token returned challenge:", aws.StringValue(cognitoResponse.ChallengeName))
+		resp := Response{
+			Message: "Refresh token is invalid, expired or revoked",
+			Error:   "Token Refresh Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 401}, nil
+	}
+
+	dynamoService := dynamodb.New(sess)
+
+	signedOut, err := auth.SignedOut(dynamoService, aws.StringValue(cognitoResponse.AuthenticationResult.IdToken))
+	if err != nil {
+		log.Println("Error checking sign out (dynamo):", err)
+		resp := Response{
+			Message: "Error refreshing token",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if signedOut {
+		resp := Response{
+			Message: "Refresh token is invalid, expired or revoked",
+			Error:   "Token Refresh Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 401}, nil
+	}
+
+	// Refreshing does not hand out a new refresh token, the
+	// one passed in stays valid until it expires
+	tokens := auth.NewTokens(cognitoResponse.AuthenticationResult, time.Now())
+
+	resp := Response{
+		Message: "Successfully refreshed token",
+		Tokens:  &tokens,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	lambda.Start(RefreshToken)
+}
@@ -0,0 +1,230 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/device"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/notify"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrNoEmailChange is returned when the user has no email change in progress
+var ErrNoEmailChange = errors.New("no email change in progress")
+
+// EmailChange is an email change waiting for the new address to be verified
+type EmailChange struct {
+	From string
+	To   string
+}
+
+// StartEmailChange records the email change of the user
+// A change started earlier and never verified is replaced
+func StartEmailChange(dynamoService *dynamodb.DynamoDB, userID string, change EmailChange) error {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("SET EmailChangeFrom = :f, EmailChangeTo = :t"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {S: aws.String(change.From)},
+			":t": {S: aws.String(change.To)},
+		},
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error recording email change of %s: %v", userID, err)
+	}
+	return nil
+}
+
+// PendingEmailChange returns the email change of the user or ErrNoEmailChange
+func PendingEmailChange(dynamoService *dynamodb.DynamoDB, userID string) (EmailChange, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return EmailChange{}, fmt.Errorf("error looking up user %s: %v", userID, err)
+	}
+
+	change := EmailChange{
+		From: stringValue(dynamoResponse.Item["EmailChangeFrom"]),
+		To:   stringValue(dynamoResponse.Item["EmailChangeTo"]),
+	}
+	if change.To == "" {
+		return EmailChange{}, ErrNoEmailChange
+	}
+	return change, nil
+}
+
+// CompleteEmailChange updates every copy of the email of the user once
+// the new address was verified
+// Every step can be repeated, a failed change is completed by retrying it
+func CompleteEmailChange(dynamoService *dynamodb.DynamoDB, userID string, change EmailChange) error {
+	err := updateDeviceEmails(dynamoService, userID, change.To)
+	if err != nil {
+		return err
+	}
+
+	err = updateMemberEmails(dynamoService, userID, change.To)
+	if err != nil {
+		return err
+	}
+
+	err = updateChannelEmails(dynamoService, userID, change)
+	if err != nil {
+		return err
+	}
+
+	// The pending change is removed last, until then verifying again
+	// picks up where a failed change stopped
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression:    aws.String("SET Email = :t REMOVE EmailChangeFrom, EmailChangeTo"),
+		ConditionExpression: aws.String("EmailChangeTo = :t"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {S: aws.String(change.To)},
+		},
+	}
+
+	_, err = dynamoService.UpdateItem(&dynamoInput)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("error completing email change of %s: %v", userID, err)
+	}
+	return nil
+}
+
+// updateDeviceEmails sets the OwnerEmail displayed with the devices of the user
+// Devices are owned by the sub, so the user keeps them either way
+func updateDeviceEmails(dynamoService *dynamodb.DynamoDB, userID string, email string) error {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(device.TableName),
+		IndexName:              aws.String(device.OwnerIndex),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(userID)},
+		},
+		ProjectionExpression: aws.String("MAC"),
+	}
+
+	var macs []string
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			macs = append(macs, stringValue(item["MAC"]))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing devices of %s: %v", userID, err)
+	}
+
+	for _, mac := range macs {
+		updateInput := dynamodb.UpdateItemInput{
+			TableName: aws.String(device.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"MAC": {S: aws.String(mac)},
+			},
+			UpdateExpression: aws.String("SET OwnerEmail = :e"),
+			// The device may have been transferred in the meantime
+			ConditionExpression: aws.String("#O = :o"),
+			ExpressionAttributeNames: map[string]*string{
+				"#O": aws.String("Owner"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":e": {S: aws.String(email)},
+				":o": {S: aws.String(userID)},
+			},
+		}
+
+		_, err = dynamoService.UpdateItem(&updateInput)
+		if err != nil && !isConditionalCheckFailed(err) {
+			return fmt.Errorf("error updating owner email of device %s: %v", mac, err)
+		}
+	}
+
+	return nil
+}
+
+// updateMemberEmails sets the Email displayed with the memberships of the user
+func updateMemberEmails(dynamoService *dynamodb.DynamoDB, userID string, email string) error {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(org.MembersTable),
+		IndexName:              aws.String(org.UserIndex),
+		KeyConditionExpression: aws.String("userID = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {S: aws.String(userID)},
+		},
+		ProjectionExpression: aws.String("OrgID"),
+	}
+
+	var orgIDs []string
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			orgIDs = append(orgIDs, stringValue(item["OrgID"]))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing memberships of %s: %v", userID, err)
+	}
+
+	for _, orgID := range orgIDs {
+		updateInput := dynamodb.UpdateItemInput{
+			TableName: aws.String(org.MembersTable),
+			Key: map[string]*dynamodb.AttributeValue{
+				"OrgID":  {S: aws.String(orgID)},
+				"userID": {S: aws.String(userID)},
+			},
+			UpdateExpression: aws.String("SET Email = :e"),
+			// The user may have left the organization in the meantime
+			ConditionExpression: aws.String("attribute_exists(userID)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":e": {S: aws.String(email)},
+			},
+		}
+
+		_, err = dynamoService.UpdateItem(&updateInput)
+		if err != nil && !isConditionalCheckFailed(err) {
+			return fmt.Errorf("error updating email of member %s of %s: %v", userID, orgID, err)
+		}
+	}
+
+	return nil
+}
+
+// updateChannelEmails points the email channels sending to the old
+// address at the new one, channels sending anywhere else are left alone
+func updateChannelEmails(dynamoService *dynamodb.DynamoDB, userID string, change EmailChange) error {
+	channels, err := notify.ListChannels(dynamoService, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		if channel.Type != notify.ChannelEmail || auth.NormalizeEmail(channel.Destination) != auth.NormalizeEmail(change.From) {
+			continue
+		}
+		err = notify.SetDestination(dynamoService, userID, channel.ChannelID, change.To)
+		if err != nil && err != notify.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Profile limits
+const (
+	MaxDisplayName     = 50
+	MaxPreferences     = 20
+	MaxPreferenceKey   = 50
+	MaxPreferenceValue = 200
+)
+
+// ErrConflict is returned when the profile changed while it was being updated
+var ErrConflict = errors.New("profile changed concurrently")
+
+// locale is a BCP 47 language tag like en, en-US or zh-Hant-TW
+var locale = regexp.MustCompile("^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|[0-9]{3}))?$")
+
+// Profile is the profile of a user
+type Profile struct {
+	UserID      string `json:"userID"`
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName,omitempty"`
+	// TimeZone is an IANA time zone name like Europe/Berlin
+	TimeZone string `json:"timeZone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	// Preferences are free form settings of the apps
+	Preferences map[string]string `json:"preferences"`
+	CreatedAt   string            `json:"createdAt"`
+	UpdatedAt   string            `json:"updatedAt,omitempty"`
+}
+
+// Update changes the fields of a profile that are not nil
+// An empty string clears the field, a nil preference removes it
+type Update struct {
+	DisplayName *string            `json:"displayName"`
+	TimeZone    *string            `json:"timeZone"`
+	Locale      *string            `json:"locale"`
+	Preferences map[string]*string `json:"preferences"`
+}
+
+// Validate returns an error describing the first invalid field
+// The message is meant to be returned to the user as is
+func (u Update) Validate() error {
+	if u.DisplayName == nil && u.TimeZone == nil && u.Locale == nil && len(u.Preferences) == 0 {
+		return errors.New("request JSON needs at least one of displayName, timeZone, locale or preferences")
+	}
+	if u.DisplayName != nil && utf8.RuneCountInString(*u.DisplayName) > MaxDisplayName {
+		return errors.New("Provided displayName too long")
+	}
+	if u.TimeZone != nil && *u.TimeZone != "" {
+		// LoadLocation treats "Local" and "" as the zone of the lambda
+		_, err := time.LoadLocation(*u.TimeZone)
+		if err != nil || *u.TimeZone == "Local" {
+			return fmt.Errorf("Invalid time zone provided: %s", *u.TimeZone)
+		}
+	}
+	if u.Locale != nil && *u.Locale != "" && !locale.MatchString(*u.Locale) {
+		return fmt.Errorf("Invalid locale provided: %s", *u.Locale)
+	}
+	for key, value := range u.Preferences {
+		if key == "" || utf8.RuneCountInString(key) > MaxPreferenceKey {
+			return fmt.Errorf("Preference names need to be between 1 and %d characters long", MaxPreferenceKey)
+		}
+		if value != nil && utf8.RuneCountInString(*value) > MaxPreferenceValue {
+			return fmt.Errorf("Provided value of preference %s too long", key)
+		}
+	}
+	return nil
+}
+
+// Apply returns the profile with the update applied
+func (p Profile) Apply(u Update) Profile {
+	if u.DisplayName != nil {
+		p.DisplayName = *u.DisplayName
+	}
+	if u.TimeZone != nil {
+		p.TimeZone = *u.TimeZone
+	}
+	if u.Locale != nil {
+		p.Locale = *u.Locale
+	}
+
+	preferences := make(map[string]string, len(p.Preferences))
+	for key, value := range p.Preferences {
+		preferences[key] = value
+	}
+	for key, value := range u.Preferences {
+		if value == nil {
+			delete(preferences, key)
+		} else {
+			preferences[key] = *value
+		}
+	}
+	p.Preferences = preferences
+
+	return p
+}
+
+// GetProfile returns the profile of the user
+// Users whose item was not written on registration get one created
+func GetProfile(dynamoService *dynamodb.DynamoDB, userID string, email string) (Profile, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Profile{}, fmt.Errorf("error looking up user %s: %v", userID, err)
+	}
+
+	item := dynamoResponse.Item
+	// Items written by registration only have the userID
+	if len(item) == 0 || item["CreatedAt"] == nil {
+		item, err = create(dynamoService, userID, email)
+		if err != nil {
+			return Profile{}, err
+		}
+	}
+
+	return profileFromItem(item), nil
+}
+
+// PutProfile stores the profile fields of the user
+// previous is the UpdatedAt the profile was read with, if the
+// profile was updated since ErrConflict is returned
+func PutProfile(dynamoService *dynamodb.DynamoDB, profile Profile, previous string) error {
+	values := map[string]*dynamodb.AttributeValue{
+		"Email":       {S: aws.String(profile.Email)},
+		"UpdatedAt":   {S: aws.String(profile.UpdatedAt)},
+		"DisplayName": nil,
+		"TimeZone":    nil,
+		"Locale":      nil,
+		"Preferences": nil,
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"DisplayName", profile.DisplayName},
+		{"TimeZone", profile.TimeZone},
+		{"Locale", profile.Locale},
+	} {
+		if field.value != "" {
+			values[field.name] = &dynamodb.AttributeValue{S: aws.String(field.value)}
+		}
+	}
+	if len(profile.Preferences) > 0 {
+		preferences := make(map[string]*dynamodb.AttributeValue, len(profile.Preferences))
+		for key, value := range profile.Preferences {
+			preferences[key] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+		values["Preferences"] = &dynamodb.AttributeValue{M: preferences}
+	}
+
+	// Empty fields are removed rather than stored as empty strings
+	expressionAttributeNames := make(map[string]*string)
+	expressionAttributeValues := make(map[string]*dynamodb.AttributeValue)
+	var setClauses, removeClauses []string
+	for attributeName, attributeValue := range values {
+		placeholder := strconv.Itoa(len(expressionAttributeNames))
+		expressionAttributeNames["#a"+placeholder] = aws.String(attributeName)
+		if attributeValue == nil {
+			removeClauses = append(removeClauses, "#a"+placeholder)
+			continue
+		}
+		setClauses = append(setClauses, fmt.Sprintf("#a%s = :v%s", placeholder, placeholder))
+		expressionAttributeValues[":v"+placeholder] = attributeValue
+	}
+
+	updateExpression := "SET " + strings.Join(setClauses, ", ")
+	if len(removeClauses) > 0 {
+		updateExpression += " REMOVE " + strings.Join(removeClauses, ", ")
+	}
+
+	expressionAttributeNames["#u"] = aws.String("UpdatedAt")
+	conditionExpression := "attribute_exists(userID) AND attribute_not_exists(#u)"
+	if previous != "" {
+		conditionExpression = "#u = :previous"
+		expressionAttributeValues[":previous"] = &dynamodb.AttributeValue{S: aws.String(previous)}
+	}
+
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(profile.UserID)},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(conditionExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+	}
+
+	_, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("error updating user %s: %v", profile.UserID, err)
+	}
+	return nil
+}
+
+// create adds the fields every profile has, leaving anything
+// else already stored for the user alone
+func create(dynamoService *dynamodb.DynamoDB, userID string, email string) (map[string]*dynamodb.AttributeValue, error) {
+	dynamoInput := dynamodb.UpdateItemInput{
+		TableName: aws.String(auth.UsersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"userID": {S: aws.String(userID)},
+		},
+		UpdateExpression: aws.String("SET CreatedAt = if_not_exists(CreatedAt, :c), Email = :e"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			":e": {S: aws.String(email)},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+	}
+
+	dynamoResponse, err := dynamoService.UpdateItem(&dynamoInput)
+	if err != nil {
+		return nil, fmt.Errorf("error creating user %s: %v", userID, err)
+	}
+	return dynamoResponse.Attributes, nil
+}
+
+func profileFromItem(item map[string]*dynamodb.AttributeValue) Profile {
+	profile := Profile{
+		UserID:      stringValue(item["userID"]),
+		Email:       stringValue(item["Email"]),
+		DisplayName: stringValue(item["DisplayName"]),
+		TimeZone:    stringValue(item["TimeZone"]),
+		Locale:      stringValue(item["Locale"]),
+		Preferences: map[string]string{},
+		CreatedAt:   stringValue(item["CreatedAt"]),
+		UpdatedAt:   stringValue(item["UpdatedAt"]),
+	}
+	if item["Preferences"] != nil {
+		for key, value := range item["Preferences"].M {
+			profile.Preferences[key] = stringValue(value)
+		}
+	}
+	return profile
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
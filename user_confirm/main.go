@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+)
+
+// UserConfirmEvent defines the request structure of this user confirmation request
+type UserConfirmEvent struct {
+	Email string `json:"email"`
+	// Code is the confirmation code emailed on registration
+	Code string `json:"code"`
+}
+
+// Response defines the response structure to this user confirmation request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// ConfirmUser is the lambda function handler
+// it confirms a registered user with the code sent to them
+func ConfirmUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt UserConfirmEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Email == "" {
+		resp := Response{
+			Message: "email missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Code == "" {
+		resp := Response{
+			Message: "code missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.ConfirmSignUpInput{
+		ClientId:         aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		Username:         aws.String(evt.Email),
+		ConfirmationCode: aws.String(evt.Code),
+	}
+
+	_, err = cognitoService.ConfirmSignUp(&cognitoInput)
+	if err != nil {
+		log.Println("Error confirming user (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		// Confirming a user that is already confirmed
+		case cognitoidentityprovider.ErrCodeNotAuthorizedException:
+			statusCode, message = 409, "User is already confirmed"
+		case cognitoidentityprovider.ErrCodeUserNotFoundException:
+			statusCode, message = 404, fmt.Sprintf("User not found: %s", evt.Email)
+		case cognitoidentityprovider.ErrCodeExpiredCodeException:
+			message = "Code has expired, request a new one"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "User Confirmation Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: statusCode,
+		}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully confirmed user %s", evt.Email),
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	lambda.Start(ConfirmUser)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/signup"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/user"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"regexp"
+)
+
+// ChangeEmailEvent defines the request structure of this email change request
+type ChangeEmailEvent struct {
+	Email string `json:"email"`
+}
+
+// Response defines the response structure to this email change request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+	// CodeDestination is the masked address the verification code was sent to
+	CodeDestination string `json:"CodeDestination,omitempty"`
+}
+
+// ChangeEmail is the lambda function handler
+// it starts changing the email of the logged in user, the new address
+// is used once it was verified with the code sent to it
+func ChangeEmail(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	accessToken := auth.AccessToken(req.Headers)
+	if accessToken == "" {
+		resp := Response{
+			Message: auth.AccessTokenHeader + " header missing from request",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt ChangeEmailEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the Email
+	validEmail, _ := regexp.MatchString("(^[a-zA-Z0-9_.+-]+@[a-zA-Z0-9-]+.[a-zA-Z0-9-.]+$)", evt.Email)
+	if validEmail == false {
+		resp := Response{
+			Message: fmt.Sprintf("Invalid Email Address Provided: %s", evt.Email),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+	emailFromToken, _ := typedAuthorizer["email"].(string)
+
+	if auth.NormalizeEmail(evt.Email) == auth.NormalizeEmail(emailFromToken) {
+		resp := Response{
+			Message: "Provided email is already the email of the user",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+	}
+
+	// Changing the email must not get around the domains the
+	// registration policy refuses
+	err = signup.PolicyFromEnv().CheckDomain(evt.Email)
+	if rejection, ok := err.(signup.Rejection); ok {
+		resp := Response{
+			Message: rejection.Message,
+			Error:   "Email Change Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 403}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	// The change is recorded before cognito sends the code, so the
+	// verification always knows which address is being replaced
+	err = user.StartEmailChange(dynamoService, subFromToken, user.EmailChange{
+		From: emailFromToken,
+		To:   evt.Email,
+	})
+	if err != nil {
+		log.Println("Error recording email change (dynamo)", err)
+		resp := Response{
+			Message: "Error changing email",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	cognitoInput := cognitoidentityprovider.UpdateUserAttributesInput{
+		AccessToken: aws.String(accessToken),
+		UserAttributes: []*cognitoidentityprovider.AttributeType{
+			{
+				Name:  aws.String("email"),
+				Value: aws.String(evt.Email),
+			},
+		},
+	}
+
+	cognitoResponse, err := cognitoService.UpdateUserAttributes(&cognitoInput)
+	if err != nil {
+		log.Println("Error updating email (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		case cognitoidentityprovider.ErrCodeAliasExistsException,
+			cognitoidentityprovider.ErrCodeUsernameExistsException:
+			statusCode, message = 409, "Email is already in use"
+		case cognitoidentityprovider.ErrCodeNotAuthorizedException:
+			message = "Invalid access token"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Email Change Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	resp := Response{
+		Message: "Verification code sent to the new email, verify it to complete the change",
+	}
+	for _, details := range cognitoResponse.CodeDeliveryDetailsList {
+		resp.CodeDestination = aws.StringValue(details.Destination)
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 202}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, ChangeEmail))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/user"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// VerifyEmailEvent defines the request structure of this email verification request
+type VerifyEmailEvent struct {
+	// Code is the verification code sent to the new email
+	Code string `json:"code"`
+}
+
+// Response defines the response structure to this email verification request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// VerifyEmail is the lambda function handler
+// it verifies the new email of the logged in user with the code sent to it
+// and updates every copy of the email stored for the user
+func VerifyEmail(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	accessToken := auth.AccessToken(req.Headers)
+	if accessToken == "" {
+		resp := Response{
+			Message: auth.AccessTokenHeader + " header missing from request",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var evt VerifyEmailEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+	dynamoService := dynamodb.New(sess)
+
+	change, err := user.PendingEmailChange(dynamoService, subFromToken)
+	if err == user.ErrNoEmailChange {
+		resp := Response{
+			Message: "No email change in progress",
+			Error:   "Conflict",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up email change (dynamo)", err)
+		resp := Response{
+			Message: "Error verifying email",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	cognitoResponse, err := cognitoService.GetUser(&cognitoidentityprovider.GetUserInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		log.Println("Error getting user (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		if statusCode == 401 {
+			message = "Invalid access token"
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Email Verification Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+	}
+
+	var cognitoEmail, emailVerified string
+	for _, attribute := range cognitoResponse.UserAttributes {
+		switch aws.StringValue(attribute.Name) {
+		case "email":
+			cognitoEmail = aws.StringValue(attribute.Value)
+		case "email_verified":
+			emailVerified = aws.StringValue(attribute.Value)
+		}
+	}
+
+	// Cognito only ever holds the latest requested email
+	if auth.NormalizeEmail(cognitoEmail) != auth.NormalizeEmail(change.To) {
+		resp := Response{
+			Message: "Email of the user changed since, start the change again",
+			Error:   "Conflict",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	// An email verified by an earlier request whose updates failed
+	// only needs the updates repeated
+	if emailVerified != "true" {
+		if evt.Code == "" {
+			resp := Response{
+				Message: "code missing from request JSON",
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 400}, nil
+		}
+
+		cognitoInput := cognitoidentityprovider.VerifyUserAttributeInput{
+			AccessToken:   aws.String(accessToken),
+			AttributeName: aws.String("email"),
+			Code:          aws.String(evt.Code),
+		}
+
+		_, err = cognitoService.VerifyUserAttribute(&cognitoInput)
+		if err != nil {
+			log.Println("Error verifying email (cognito):", err)
+			statusCode, message := auth.ErrorStatus(err)
+			if statusCode == 401 {
+				message = "Invalid access token"
+			}
+			resp := Response{
+				Message: message,
+				Error:   "Email Verification Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: statusCode}, nil
+		}
+	}
+
+	err = user.CompleteEmailChange(dynamoService, subFromToken, change)
+	if err != nil {
+		log.Println("Error completing email change (dynamo)", err)
+		resp := Response{
+			Message: "Email verified but not updated everywhere, verify again to retry",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully changed email",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.AccountManage, VerifyEmail))
+}
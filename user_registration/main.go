@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/signup"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -27,18 +28,35 @@ type UserRegEvent struct {
 type Response struct {
 	Message string `json:"Response"`
 	Error   string `json:"Error"`
+	// Confirmed is false until the user confirms the code sent to them
+	Confirmed *bool `json:"Confirmed,omitempty"`
+	// CodeDestination is the masked address the confirmation code was sent to
+	CodeDestination string `json:"CodeDestination,omitempty"`
+}
+
+// cognitoAPI is the part of cognito the registration uses
+type cognitoAPI interface {
+	SignUp(*cognitoidentityprovider.SignUpInput) (*cognitoidentityprovider.SignUpOutput, error)
+	AdminDeleteUser(*cognitoidentityprovider.AdminDeleteUserInput) (*cognitoidentityprovider.AdminDeleteUserOutput, error)
+}
+
+// dynamoAPI is the part of dynamo the registration uses
+type dynamoAPI interface {
+	PutItem(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+}
+
+// Registration holds what CreateUser needs, main sets it up once
+// per container
+type Registration struct {
+	Cognito     cognitoAPI
+	Dynamo      dynamoAPI
+	AppClientID string
+	UserPoolID  string
 }
 
 // CreateUser is the lambda function handler
 // it processes the creation of the cognito user
-func CreateUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var cognitoAppClientID string
-	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
-		log.Fatal("COGNITO_APP_CLIENT_ID not set")
-	} else {
-		cognitoAppClientID = os.Getenv("COGNITO_APP_CLIENT_ID")
-	}
-
+func (r *Registration) CreateUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var evt UserRegEvent
 	err := json.Unmarshal([]byte(req.Body), &evt)
 	if err != nil {
@@ -107,51 +125,63 @@ func CreateUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.
 		}, nil
 	}
 
-	sess := session.Must(session.NewSession())
-
-	cognitoService := cognitoidentityprovider.New(sess)
-	dynamoService := dynamodb.New(sess)
-
 	cognitoInput := cognitoidentityprovider.SignUpInput{
-		ClientId: &cognitoAppClientID,
+		ClientId: aws.String(r.AppClientID),
 		Username: &evt.Email,
 		Password: &evt.Password,
 	}
 
-	cognitoResponse, err := cognitoService.SignUp(&cognitoInput)
+	cognitoResponse, err := r.Cognito.SignUp(&cognitoInput)
 	if err != nil {
 		log.Println("Error Creating User (cognito):", err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case "UsernameExistsException":
-				resp := Response{
-					Message: fmt.Sprintf("Email is already registered: %s", evt.Email),
-					Error:   "User Creation Error",
-				}
-				marshalledResponse, err := json.Marshal(resp)
-				if err != nil {
-					log.Println("Error marshalling response:", resp)
-					panic(err)
-				}
-				return events.APIGatewayProxyResponse{
-					Body:       string(marshalledResponse),
-					StatusCode: 409,
-				}, nil
-			default:
-				resp := Response{
-					Message: "Error creating cognito user",
-					Error:   "User Creation Error",
-				}
-				marshalledResponse, err := json.Marshal(resp)
-				if err != nil {
-					log.Println("Error marshalling response:", resp)
-					panic(err)
-				}
-				return events.APIGatewayProxyResponse{
-					Body:       string(marshalledResponse),
-					StatusCode: 500,
-				}, nil
+		// Sign ups the registration policy rejected carry the reason
+		// the PreSignUp trigger gave
+		if message, rejected := signup.RejectionMessage(err); rejected {
+			resp := Response{
+				Message: message,
+				Error:   "User Creation Error",
 			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 403,
+			}, nil
+		}
+		// Errors that are not from cognito are treated like any other
+		// failure, cognitoResponse is nil either way
+		switch auth.Code(err) {
+		case cognitoidentityprovider.ErrCodeUsernameExistsException:
+			resp := Response{
+				Message: fmt.Sprintf("Email is already registered: %s", evt.Email),
+				Error:   "User Creation Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 409,
+			}, nil
+		default:
+			resp := Response{
+				Message: "Error creating cognito user",
+				Error:   "User Creation Error",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 500,
+			}, nil
 		}
 	}
 
@@ -175,9 +205,21 @@ func CreateUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.
 		Item:      dynamoInputItem,
 	}
 
-	_, err = dynamoService.PutItem(&dynamoInput)
+	_, err = r.Dynamo.PutItem(&dynamoInput)
 	if err != nil {
 		log.Println("Error Creating User (dynamo):", err)
+
+		// Without rolling back the cognito user the email would stay
+		// registered and every retry would fail with a conflict
+		rollbackInput := cognitoidentityprovider.AdminDeleteUserInput{
+			Username:   &evt.Email,
+			UserPoolId: aws.String(r.UserPoolID),
+		}
+		_, rollbackErr := r.Cognito.AdminDeleteUser(&rollbackInput)
+		if rollbackErr != nil {
+			log.Printf("Error rolling back cognito user %s: %v\n", aws.StringValue(userUUID), rollbackErr)
+		}
+
 		resp := Response{
 			Message: "Error creating user",
 			Error:   "User Creation Error",
@@ -194,7 +236,11 @@ func CreateUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.
 	}
 
 	resp := Response{
-		Message: fmt.Sprintf("Successfully created user %s", evt.Email),
+		Message:   fmt.Sprintf("Successfully created user %s", evt.Email),
+		Confirmed: cognitoResponse.UserConfirmed,
+	}
+	if cognitoResponse.CodeDeliveryDetails != nil {
+		resp.CodeDestination = aws.StringValue(cognitoResponse.CodeDeliveryDetails.Destination)
 	}
 	marshalledResponse, err := json.Marshal(resp)
 	if err != nil {
@@ -216,5 +262,22 @@ func main() {
 		log.Fatal("AWS_REGION not set")
 	}
 
-	lambda.Start(CreateUser)
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	sess := session.Must(session.NewSession())
+
+	registration := &Registration{
+		Cognito:     cognitoidentityprovider.New(sess),
+		Dynamo:      dynamodb.New(sess),
+		AppClientID: os.Getenv("COGNITO_APP_CLIENT_ID"),
+		UserPoolID:  os.Getenv("COGNITO_USER_POOL_ID"),
+	}
+
+	lambda.Start(registration.CreateUser)
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"testing"
+)
+
+type fakeCognito struct {
+	signUpErr    error
+	deleteErr    error
+	deletedUsers []string
+}
+
+func (f *fakeCognito) SignUp(input *cognitoidentityprovider.SignUpInput) (*cognitoidentityprovider.SignUpOutput, error) {
+	if f.signUpErr != nil {
+		return nil, f.signUpErr
+	}
+	return &cognitoidentityprovider.SignUpOutput{
+		UserSub:       aws.String("sub-1"),
+		UserConfirmed: aws.Bool(false),
+	}, nil
+}
+
+func (f *fakeCognito) AdminDeleteUser(input *cognitoidentityprovider.AdminDeleteUserInput) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	f.deletedUsers = append(f.deletedUsers, aws.StringValue(input.Username))
+	if f.deleteErr != nil {
+		return nil, f.deleteErr
+	}
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, nil
+}
+
+type fakeDynamo struct {
+	putErr error
+	items  []map[string]*dynamodb.AttributeValue
+}
+
+func (f *fakeDynamo) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.items = append(f.items, input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func register(t *testing.T, cognito *fakeCognito, dynamo *fakeDynamo) (int, Response) {
+	registration := &Registration{
+		Cognito:     cognito,
+		Dynamo:      dynamo,
+		AppClientID: "client",
+		UserPoolID:  "pool",
+	}
+	req := events.APIGatewayProxyRequest{
+		Body: `{"email":"user@example.com","password":"Passw0rd!"}`,
+	}
+
+	res, err := registration.CreateUser(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var resp Response
+	err = json.Unmarshal([]byte(res.Body), &resp)
+	if err != nil {
+		t.Fatalf("error unmarshalling response %s: %v", res.Body, err)
+	}
+	return res.StatusCode, resp
+}
+
+func TestCreateUser(t *testing.T) {
+	cognito := &fakeCognito{}
+	dynamo := &fakeDynamo{}
+
+	status, _ := register(t, cognito, dynamo)
+	if status != 200 {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(dynamo.items) != 1 || aws.StringValue(dynamo.items[0]["userID"].S) != "sub-1" {
+		t.Errorf("users item not written for sub-1: %v", dynamo.items)
+	}
+}
+
+func TestCreateUserSignUpError(t *testing.T) {
+	cognito := &fakeCognito{signUpErr: errors.New("connection reset")}
+	dynamo := &fakeDynamo{}
+
+	status, resp := register(t, cognito, dynamo)
+	if status != 500 {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	if resp.Error != "User Creation Error" {
+		t.Errorf("error = %q, want User Creation Error", resp.Error)
+	}
+	if len(dynamo.items) != 0 {
+		t.Errorf("users item written after a failed sign up")
+	}
+}
+
+func TestCreateUserUsernameExists(t *testing.T) {
+	cognito := &fakeCognito{
+		signUpErr: awserr.New(cognitoidentityprovider.ErrCodeUsernameExistsException, "User already exists", nil),
+	}
+	dynamo := &fakeDynamo{}
+
+	status, _ := register(t, cognito, dynamo)
+	if status != 409 {
+		t.Fatalf("status = %d, want 409", status)
+	}
+	if len(dynamo.items) != 0 {
+		t.Errorf("users item written for an existing user")
+	}
+}
+
+func TestCreateUserPutItemErrorRollsBack(t *testing.T) {
+	cognito := &fakeCognito{}
+	dynamo := &fakeDynamo{putErr: errors.New("throttled")}
+
+	status, _ := register(t, cognito, dynamo)
+	if status != 500 {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	if len(cognito.deletedUsers) != 1 || cognito.deletedUsers[0] != "user@example.com" {
+		t.Errorf("deleted users = %v, want [user@example.com]", cognito.deletedUsers)
+	}
+}
+
+func TestCreateUserPutItemErrorRollbackFails(t *testing.T) {
+	cognito := &fakeCognito{deleteErr: errors.New("throttled")}
+	dynamo := &fakeDynamo{putErr: errors.New("throttled")}
+
+	status, resp := register(t, cognito, dynamo)
+	if status != 500 {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	if resp.Error != "User Creation Error" {
+		t.Errorf("error = %q, want User Creation Error", resp.Error)
+	}
+	if len(cognito.deletedUsers) != 1 {
+		t.Errorf("rollback attempted %d times, want 1", len(cognito.deletedUsers))
+	}
+}
+
+func TestCreateUserRejected(t *testing.T) {
+	cognito := &fakeCognito{
+		signUpErr: awserr.New(cognitoidentityprovider.ErrCodeUserLambdaValidationException, "PreSignUp failed with error Registration rejected: Emails of example.com can not register.", nil),
+	}
+	dynamo := &fakeDynamo{}
+
+	status, resp := register(t, cognito, dynamo)
+	if status != 403 {
+		t.Fatalf("status = %d, want 403", status)
+	}
+	if resp.Message != "Emails of example.com can not register" {
+		t.Errorf("message = %q, want the reason of the rejection", resp.Message)
+	}
+	if len(dynamo.items) != 0 {
+		t.Errorf("users item written for a rejected sign up")
+	}
+}
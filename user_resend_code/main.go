@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"log"
+	"os"
+)
+
+// ResendCodeEvent defines the request structure of this resend code request
+type ResendCodeEvent struct {
+	Email string `json:"email"`
+}
+
+// Response defines the response structure to this resend code request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+	// CodeDestination is the masked address the confirmation code was sent to
+	CodeDestination string `json:"CodeDestination,omitempty"`
+}
+
+// ResendCode is the lambda function handler
+// it sends a new confirmation code to an unconfirmed user
+func ResendCode(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt ResendCodeEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.Email == "" {
+		resp := Response{
+			Message: "email missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	sess := session.Must(session.NewSession())
+
+	cognitoService := cognitoidentityprovider.New(sess)
+
+	cognitoInput := cognitoidentityprovider.ResendConfirmationCodeInput{
+		ClientId: aws.String(os.Getenv("COGNITO_APP_CLIENT_ID")),
+		Username: aws.String(evt.Email),
+	}
+
+	cognitoResponse, err := cognitoService.ResendConfirmationCode(&cognitoInput)
+	if err != nil {
+		log.Println("Error resending confirmation code (cognito):", err)
+		statusCode, message := auth.ErrorStatus(err)
+		switch auth.Code(err) {
+		// Cognito rejects resending to a confirmed user as an invalid parameter
+		case cognitoidentityprovider.ErrCodeInvalidParameterException:
+			statusCode, message = 409, "User is already confirmed"
+		case cognitoidentityprovider.ErrCodeUserNotFoundException:
+			statusCode, message = 404, fmt.Sprintf("User not found: %s", evt.Email)
+		}
+		resp := Response{
+			Message: message,
+			Error:   "Resend Code Error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: statusCode,
+		}, nil
+	}
+
+	resp := Response{
+		Message: fmt.Sprintf("Successfully sent a new confirmation code to %s", evt.Email),
+	}
+	if cognitoResponse.CodeDeliveryDetails != nil {
+		resp.CodeDestination = aws.StringValue(cognitoResponse.CodeDeliveryDetails.Destination)
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	lambda.Start(ResendCode)
+}
@@ -0,0 +1,419 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Dynamo tables backing the webhooks
+const (
+	// SubscriptionsTable hash key is Owner, range key is WebhookID
+	SubscriptionsTable = "webhooks"
+	// DeliveriesTable hash key is WebhookID, range key is DeliveryID
+	DeliveriesTable = "webhook_deliveries"
+	// DeadLettersTable has the same keys as DeliveriesTable
+	DeadLettersTable = "webhook_dead_letters"
+	// RetryIndex is a sparse index of DeliveriesTable
+	// Hash key is Pending, range key is NextAttempt
+	RetryIndex = "RetryIndex"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusFailed is only used for test events, which are not retried
+	StatusFailed = "failed"
+	// StatusDead means the delivery was moved to the dead letter table
+	StatusDead = "dead"
+)
+
+// TimestampFormat is fixed width so that deliveries sort
+// lexicographically in the order they were created
+const TimestampFormat = "2006-01-02T15:04:05.000000Z"
+
+// deliveryRetention is how long the delivery log is kept
+const deliveryRetention = 30 * 24 * time.Hour
+
+// ErrNotFound is returned when a subscription does not exist
+var ErrNotFound = errors.New("webhook not found")
+
+// Subscription describes where and what to deliver
+type Subscription struct {
+	WebhookID string   `json:"id"`
+	Owner     string   `json:"owner"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	// Secret is only returned when the subscription is created
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Delivery is an entry of the delivery log
+type Delivery struct {
+	WebhookID      string `json:"webhookID"`
+	DeliveryID     string `json:"id"`
+	Owner          string `json:"-"`
+	EventType      string `json:"event"`
+	Payload        string `json:"payload"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"lastStatusCode,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	NextAttempt    string `json:"nextAttempt,omitempty"`
+	CreatedAt      string `json:"createdAt"`
+	UpdatedAt      string `json:"updatedAt"`
+}
+
+// Subscribed reports whether the subscription wants the event type
+func (s Subscription) Subscribed(eventType string) bool {
+	if eventType == EventTest {
+		return true
+	}
+	for _, subscribed := range s.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDelivery returns a pending delivery of the payload to the subscription
+func NewDelivery(subscription Subscription, eventType string, payload string) Delivery {
+	now := time.Now().UTC()
+	return Delivery{
+		WebhookID:  subscription.WebhookID,
+		DeliveryID: now.Format(TimestampFormat) + "-" + NewID()[:8],
+		Owner:      subscription.Owner,
+		EventType:  eventType,
+		Payload:    payload,
+		Status:     StatusPending,
+		CreatedAt:  now.Format(TimestampFormat),
+		UpdatedAt:  now.Format(TimestampFormat),
+	}
+}
+
+// PutSubscription stores the subscription
+func PutSubscription(dynamoService *dynamodb.DynamoDB, subscription Subscription) error {
+	// TODO: dynamodbattribute.MarshalMap does not work!? Need to figure out why, for now, we'll make the
+	// required structs manually
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(subscription.Owner)},
+			"WebhookID": {S: aws.String(subscription.WebhookID)},
+			"URL":       {S: aws.String(subscription.URL)},
+			"Events":    {SS: aws.StringSlice(subscription.Events)},
+			"Secret":    {S: aws.String(subscription.Secret)},
+			"CreatedAt": {S: aws.String(subscription.CreatedAt)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(WebhookID)"),
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing webhook %s: %v", subscription.WebhookID, err)
+	}
+	return nil
+}
+
+// GetSubscription returns the subscription or ErrNotFound
+func GetSubscription(dynamoService *dynamodb.DynamoDB, owner string, webhookID string) (Subscription, error) {
+	dynamoInput := dynamodb.GetItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"WebhookID": {S: aws.String(webhookID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	dynamoResponse, err := dynamoService.GetItem(&dynamoInput)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("error looking up webhook %s: %v", webhookID, err)
+	}
+	if len(dynamoResponse.Item) == 0 {
+		return Subscription{}, ErrNotFound
+	}
+
+	return subscriptionFromItem(dynamoResponse.Item), nil
+}
+
+// ListSubscriptions returns every subscription of the owner
+func ListSubscriptions(dynamoService *dynamodb.DynamoDB, owner string) ([]Subscription, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(SubscriptionsTable),
+		KeyConditionExpression: aws.String("#O = :o"),
+		ExpressionAttributeNames: map[string]*string{
+			"#O": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {S: aws.String(owner)},
+		},
+	}
+
+	subscriptions := []Subscription{}
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			subscriptions = append(subscriptions, subscriptionFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks of %s: %v", owner, err)
+	}
+
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes the subscription or returns ErrNotFound
+// The delivery log is left to expire on its own
+func DeleteSubscription(dynamoService *dynamodb.DynamoDB, owner string, webhookID string) error {
+	dynamoInput := dynamodb.DeleteItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Owner":     {S: aws.String(owner)},
+			"WebhookID": {S: aws.String(webhookID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(WebhookID)"),
+	}
+
+	_, err := dynamoService.DeleteItem(&dynamoInput)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting webhook %s: %v", webhookID, err)
+	}
+	return nil
+}
+
+// PutDelivery stores the delivery in the delivery log
+// Pending deliveries are also written to the sparse retry index
+func PutDelivery(dynamoService *dynamodb.DynamoDB, delivery Delivery) error {
+	expiresAt := time.Now().Add(deliveryRetention).Unix()
+
+	item := map[string]*dynamodb.AttributeValue{
+		"WebhookID":  {S: aws.String(delivery.WebhookID)},
+		"DeliveryID": {S: aws.String(delivery.DeliveryID)},
+		"Owner":      {S: aws.String(delivery.Owner)},
+		"EventType":  {S: aws.String(delivery.EventType)},
+		"Payload":    {S: aws.String(delivery.Payload)},
+		"Status":     {S: aws.String(delivery.Status)},
+		"Attempts":   {N: aws.String(strconv.Itoa(delivery.Attempts))},
+		"CreatedAt":  {S: aws.String(delivery.CreatedAt)},
+		"UpdatedAt":  {S: aws.String(delivery.UpdatedAt)},
+		"ExpiresAt":  {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+	}
+	if delivery.LastStatusCode != 0 {
+		item["LastStatusCode"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(delivery.LastStatusCode))}
+	}
+	if delivery.LastError != "" {
+		item["LastError"] = &dynamodb.AttributeValue{S: aws.String(delivery.LastError)}
+	}
+	if delivery.Status == StatusPending && delivery.NextAttempt != "" {
+		item["NextAttempt"] = &dynamodb.AttributeValue{S: aws.String(delivery.NextAttempt)}
+		item["Pending"] = &dynamodb.AttributeValue{S: aws.String(StatusPending)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(DeliveriesTable),
+		Item:      item,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return fmt.Errorf("error storing delivery %s: %v", delivery.DeliveryID, err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery log of a subscription, newest first
+// startDeliveryID continues a previous listing, the returned string
+// is the delivery to continue from or empty if there are no more
+func ListDeliveries(dynamoService *dynamodb.DynamoDB, webhookID string, limit int64, startDeliveryID string) ([]Delivery, string, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(DeliveriesTable),
+		KeyConditionExpression: aws.String("WebhookID = :w"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":w": {S: aws.String(webhookID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(limit),
+	}
+	if startDeliveryID != "" {
+		dynamoInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"WebhookID":  {S: aws.String(webhookID)},
+			"DeliveryID": {S: aws.String(startDeliveryID)},
+		}
+	}
+
+	dynamoResponse, err := dynamoService.Query(&dynamoInput)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing deliveries of %s: %v", webhookID, err)
+	}
+
+	deliveries := make([]Delivery, 0, len(dynamoResponse.Items))
+	for _, item := range dynamoResponse.Items {
+		deliveries = append(deliveries, deliveryFromItem(item))
+	}
+
+	var next string
+	if dynamoResponse.LastEvaluatedKey != nil {
+		next = stringValue(dynamoResponse.LastEvaluatedKey["DeliveryID"])
+	}
+
+	return deliveries, next, nil
+}
+
+// DueDeliveries returns the pending deliveries whose next attempt is due
+func DueDeliveries(dynamoService *dynamodb.DynamoDB, now time.Time) ([]Delivery, error) {
+	dynamoInput := dynamodb.QueryInput{
+		TableName:              aws.String(DeliveriesTable),
+		IndexName:              aws.String(RetryIndex),
+		KeyConditionExpression: aws.String("Pending = :p AND NextAttempt <= :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(StatusPending)},
+			":n": {S: aws.String(now.UTC().Format(TimestampFormat))},
+		},
+	}
+
+	var deliveries []Delivery
+	err := dynamoService.QueryPages(&dynamoInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			deliveries = append(deliveries, deliveryFromItem(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing due deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
+
+// Attempt sends the delivery once and records the outcome
+// Failed deliveries are rescheduled with exponential backoff until
+// MaxAttempts is reached, after which they go to the dead letter table
+// When retry is false a failure is final, this is used for test events
+func Attempt(ctx context.Context, dynamoService *dynamodb.DynamoDB, client *http.Client, subscription Subscription, delivery Delivery, retry bool) (Delivery, error) {
+	statusCode, sendErr := Send(ctx, client, subscription, delivery)
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.UpdatedAt = now.Format(TimestampFormat)
+	delivery.NextAttempt = ""
+
+	if sendErr == nil {
+		delivery.Status = StatusDelivered
+		delivery.LastError = ""
+		return delivery, PutDelivery(dynamoService, delivery)
+	}
+
+	delivery.LastError = sendErr.Error()
+
+	if !retry {
+		delivery.Status = StatusFailed
+		return delivery, PutDelivery(dynamoService, delivery)
+	}
+
+	if delivery.Attempts >= MaxAttempts {
+		return DeadLetter(dynamoService, delivery)
+	}
+
+	delivery.Status = StatusPending
+	delivery.NextAttempt = now.Add(Backoff(delivery.Attempts)).Format(TimestampFormat)
+	return delivery, PutDelivery(dynamoService, delivery)
+}
+
+// DeadLetter parks the delivery in the dead letter table and
+// marks it dead in the delivery log, returning the dead delivery
+func DeadLetter(dynamoService *dynamodb.DynamoDB, delivery Delivery) (Delivery, error) {
+	item := map[string]*dynamodb.AttributeValue{
+		"WebhookID":  {S: aws.String(delivery.WebhookID)},
+		"DeliveryID": {S: aws.String(delivery.DeliveryID)},
+		"Owner":      {S: aws.String(delivery.Owner)},
+		"EventType":  {S: aws.String(delivery.EventType)},
+		"Payload":    {S: aws.String(delivery.Payload)},
+		"Attempts":   {N: aws.String(strconv.Itoa(delivery.Attempts))},
+		"CreatedAt":  {S: aws.String(delivery.CreatedAt)},
+		"UpdatedAt":  {S: aws.String(delivery.UpdatedAt)},
+	}
+	if delivery.LastError != "" {
+		item["LastError"] = &dynamodb.AttributeValue{S: aws.String(delivery.LastError)}
+	}
+
+	dynamoInput := dynamodb.PutItemInput{
+		TableName: aws.String(DeadLettersTable),
+		Item:      item,
+	}
+
+	_, err := dynamoService.PutItem(&dynamoInput)
+	if err != nil {
+		return delivery, fmt.Errorf("error dead lettering delivery %s: %v", delivery.DeliveryID, err)
+	}
+
+	delivery.Status = StatusDead
+	return delivery, PutDelivery(dynamoService, delivery)
+}
+
+func subscriptionFromItem(item map[string]*dynamodb.AttributeValue) Subscription {
+	subscription := Subscription{
+		WebhookID: stringValue(item["WebhookID"]),
+		Owner:     stringValue(item["Owner"]),
+		URL:       stringValue(item["URL"]),
+		Secret:    stringValue(item["Secret"]),
+		CreatedAt: stringValue(item["CreatedAt"]),
+	}
+	if item["Events"] != nil {
+		subscription.Events = aws.StringValueSlice(item["Events"].SS)
+	}
+	return subscription
+}
+
+func deliveryFromItem(item map[string]*dynamodb.AttributeValue) Delivery {
+	return Delivery{
+		WebhookID:      stringValue(item["WebhookID"]),
+		DeliveryID:     stringValue(item["DeliveryID"]),
+		Owner:          stringValue(item["Owner"]),
+		EventType:      stringValue(item["EventType"]),
+		Payload:        stringValue(item["Payload"]),
+		Status:         stringValue(item["Status"]),
+		Attempts:       intValue(item["Attempts"]),
+		LastStatusCode: intValue(item["LastStatusCode"]),
+		LastError:      stringValue(item["LastError"]),
+		NextAttempt:    stringValue(item["NextAttempt"]),
+		CreatedAt:      stringValue(item["CreatedAt"]),
+		UpdatedAt:      stringValue(item["UpdatedAt"]),
+	}
+}
+
+func stringValue(value *dynamodb.AttributeValue) string {
+	if value == nil || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func intValue(value *dynamodb.AttributeValue) int {
+	if value == nil || value.N == nil {
+		return 0
+	}
+	number, _ := strconv.Atoi(*value.N)
+	return number
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
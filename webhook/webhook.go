@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Event types a subscription can ask for
+const (
+	EventDeviceRegistered    = "device.registered"
+	EventDeviceRenamed       = "device.renamed"
+	EventDeviceStatusChanged = "device.status_changed"
+	// EventTest is only sent by the send test event endpoint
+	// and is delivered regardless of the subscribed event types
+	EventTest = "webhook.test"
+)
+
+// EventTypes lists the event types a subscription can ask for
+var EventTypes = []string{
+	EventDeviceRegistered,
+	EventDeviceRenamed,
+	EventDeviceStatusChanged,
+}
+
+// MaxAttempts is the number of delivery attempts before a
+// delivery is parked in the dead letter table
+const MaxAttempts = 8
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Hermes-Signature"
+	EventHeader     = "X-Hermes-Event"
+	DeliveryHeader  = "X-Hermes-Delivery"
+)
+
+// Device is the device as sent in an event
+type Device struct {
+	MAC    string `json:"mac"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	Status string `json:"status"`
+}
+
+// Event is the JSON body posted to subscribers
+type Event struct {
+	ID        string                  `json:"id"`
+	Type      string                  `json:"type"`
+	Timestamp string                  `json:"timestamp"`
+	Device    Device                  `json:"device"`
+	Changes   map[string]audit.Change `json:"changes,omitempty"`
+}
+
+// ValidEventType reports whether subscriptions can ask for the event type
+func ValidEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that a subscription URL is an absolute https URL
+// Private addresses are rejected again when delivering, since the
+// host may resolve differently by then
+func ValidateURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("url could not be parsed")
+	}
+	if parsed.Scheme != "https" {
+		return errors.New("url must use https")
+	}
+	if parsed.Hostname() == "" {
+		return errors.New("url must have a host")
+	}
+	if parsed.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil && !publicIP(ip) {
+		return errors.New("url must not point to a private address")
+	}
+	return nil
+}
+
+// NewID returns a random hex identifier
+func NewID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value for the body
+// The signed content is the unix timestamp, a period and the body,
+// so receivers can reject replays of old deliveries
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", unix, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Backoff returns how long to wait before the next attempt
+// after the given number of failed attempts
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := 30 * time.Second << uint(attempts-1)
+	if delay > 6*time.Hour || delay <= 0 {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// NewClient returns the http client used for deliveries
+// It refuses to connect to private, loopback and link local addresses
+// and does not follow redirects
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return fmt.Errorf("refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send posts the signed payload to the subscription URL
+// It returns the response status code, or an error when the
+// request failed or the status code was not 2xx
+func Send(ctx context.Context, client *http.Client, subscription Subscription, delivery Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	httpReq, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "Hermes-Cloud-Webhooks/1.0")
+	httpReq.Header.Set(EventHeader, delivery.EventType)
+	httpReq.Header.Set(DeliveryHeader, delivery.DeliveryID)
+	httpReq.Header.Set(SignatureHeader, Sign(subscription.Secret, time.Now(), body))
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(httpResp.Body, 64*1024))
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return httpResp.StatusCode, fmt.Errorf("subscriber responded with status %d", httpResp.StatusCode)
+	}
+
+	return httpResp.StatusCode, nil
+}
+
+// NewEvent builds the payload of an event
+// The id should stay the same when the same change is dispatched
+// again so that subscribers can drop duplicates
+func NewEvent(id string, eventType string, device Device, changes map[string]audit.Change) (string, error) {
+	evt := Event{
+		ID:        id,
+		Type:      eventType,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Device:    device,
+		Changes:   changes,
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return "", err
+	}
+
+	return string(payload), nil
+}
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	privateRanges := []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"100.64.0.0/10",
+		"fc00::/7",
+	}
+	for _, cidr := range privateRanges {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
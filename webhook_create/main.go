@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxSubscriptions is the number of webhooks a user can have
+const MaxSubscriptions = 10
+
+// WebhookCreateEvent defines the request structure of this webhook creation request
+type WebhookCreateEvent struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is optional, one is generated when it is missing
+	Secret string `json:"secret"`
+}
+
+// Response defines the response structure to this webhook creation request
+type Response struct {
+	Message string                `json:"Response"`
+	Error   string                `json:"Error"`
+	Webhook *webhook.Subscription `json:"Webhook,omitempty"`
+}
+
+// CreateWebhook is the lambda function handler
+func CreateWebhook(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt WebhookCreateEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling request body",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if evt.URL == "" {
+		resp := Response{
+			Message: "url missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the URL
+	err = webhook.ValidateURL(evt.URL)
+	if err != nil || len(evt.URL) > 2048 {
+		message := "url must be at most 2048 characters"
+		if err != nil {
+			message = err.Error()
+		}
+		resp := Response{
+			Message: fmt.Sprintf("Invalid url provided: %s", message),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the event types
+	// At least one is needed and each may only appear once
+	subscribedEvents := []string{}
+	seenEvents := make(map[string]bool)
+	for _, eventType := range evt.Events {
+		if !webhook.ValidEventType(eventType) {
+			resp := Response{
+				Message: fmt.Sprintf("Unknown event type provided: %s", eventType),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+		if !seenEvents[eventType] {
+			seenEvents[eventType] = true
+			subscribedEvents = append(subscribedEvents, eventType)
+		}
+	}
+
+	if len(subscribedEvents) == 0 {
+		resp := Response{
+			Message: "events missing from request JSON",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the Secret
+	// Needs to be between 16 and 128 characters
+	if evt.Secret != "" {
+		if utf8.RuneCountInString(evt.Secret) < 16 || utf8.RuneCountInString(evt.Secret) > 128 {
+			resp := Response{
+				Message: "secret must be between 16 and 128 characters",
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+	} else {
+		evt.Secret = webhook.NewSecret()
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	// Webhooks belong to the same owner value as the devices
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	existing, err := webhook.ListSubscriptions(dynamoService, subFromToken)
+	if err != nil {
+		log.Println("Error listing webhooks (dynamo)", err)
+		resp := Response{
+			Message: "Error creating webhook",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	if len(existing) >= MaxSubscriptions {
+		resp := Response{
+			Message: fmt.Sprintf("A user can have at most %d webhooks", MaxSubscriptions),
+			Error:   "Limit exceeded",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 409}, nil
+	}
+
+	subscription := webhook.Subscription{
+		WebhookID: webhook.NewID(),
+		Owner:     subFromToken,
+		URL:       evt.URL,
+		Events:    subscribedEvents,
+		Secret:    evt.Secret,
+		CreatedAt: time.Now().UTC().Format(webhook.TimestampFormat),
+	}
+
+	err = webhook.PutSubscription(dynamoService, subscription)
+	if err != nil {
+		log.Println("Error creating webhook (dynamo)", err)
+		resp := Response{
+			Message: "Error creating webhook",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	// The secret is only ever returned here
+	resp := Response{
+		Message: fmt.Sprintf("Successfully created webhook %s", subscription.WebhookID),
+		Webhook: &subscription,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 201}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.WebhookWrite, CreateWebhook))
+}
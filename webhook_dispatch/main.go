@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/audit"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/devicestream"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/org"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// DispatchDeviceChanges is the lambda function handler
+// it consumes the devices table stream, turns every change into
+// webhook events and makes the first delivery attempt
+// Failed attempts are left pending for webhook_retry
+func DispatchDeviceChanges(ctx context.Context, evt events.DynamoDBEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	client := webhook.NewClient()
+
+	for _, record := range evt.Records {
+		oldItem := devicestream.Item(record.Change.OldImage)
+		newItem := devicestream.Item(record.Change.NewImage)
+
+		var eventTypes []string
+		switch record.EventName {
+		case "INSERT":
+			eventTypes = append(eventTypes, webhook.EventDeviceRegistered)
+		case "MODIFY":
+			if devicestream.String(oldItem, "Name") != devicestream.String(newItem, "Name") {
+				eventTypes = append(eventTypes, webhook.EventDeviceRenamed)
+			}
+			if devicestream.String(oldItem, "Status") != devicestream.String(newItem, "Status") {
+				eventTypes = append(eventTypes, webhook.EventDeviceStatusChanged)
+			}
+		}
+		if len(eventTypes) == 0 {
+			continue
+		}
+
+		device := webhook.Device{
+			MAC:    devicestream.String(newItem, "MAC"),
+			Name:   devicestream.String(newItem, "Name"),
+			Owner:  devicestream.String(newItem, "Owner"),
+			Status: devicestream.String(newItem, "Status"),
+		}
+
+		// Devices of an organization are reported to the webhooks of
+		// every member
+		users, err := org.Users(dynamoService, device.Owner)
+		if err != nil {
+			// Returning the error makes lambda retry the batch
+			return fmt.Errorf("error looking up users of %s: %v", device.MAC, err)
+		}
+
+		var subscriptions []webhook.Subscription
+		for _, userID := range users {
+			userSubscriptions, err := webhook.ListSubscriptions(dynamoService, userID)
+			if err != nil {
+				return fmt.Errorf("error listing webhooks for %s: %v", device.MAC, err)
+			}
+			subscriptions = append(subscriptions, userSubscriptions...)
+		}
+
+		changes := audit.Diff(oldItem, newItem)
+		if record.EventName == "INSERT" {
+			changes = nil
+		}
+
+		for _, eventType := range eventTypes {
+			// Lambda retries whole batches, so the event id is derived
+			// from the stream record to let subscribers drop duplicates
+			payload, err := webhook.NewEvent(record.EventID+"-"+eventType, eventType, device, changes)
+			if err != nil {
+				return fmt.Errorf("error building %s event for %s: %v", eventType, device.MAC, err)
+			}
+
+			for _, subscription := range subscriptions {
+				if !subscription.Subscribed(eventType) {
+					continue
+				}
+
+				// Store the delivery as already scheduled for a retry before
+				// attempting it, so it is never lost if this invocation dies halfway
+				delivery := webhook.NewDelivery(subscription, eventType, payload)
+				delivery.NextAttempt = time.Now().UTC().Add(webhook.Backoff(1)).Format(webhook.TimestampFormat)
+				err = webhook.PutDelivery(dynamoService, delivery)
+				if err != nil {
+					return err
+				}
+
+				delivery, err = webhook.Attempt(ctx, dynamoService, client, subscription, delivery, true)
+				if err != nil {
+					log.Println("Error recording delivery attempt:", err)
+					continue
+				}
+				if delivery.Status != webhook.StatusDelivered {
+					log.Printf("Delivery %s to webhook %s failed: %s\n", delivery.DeliveryID, subscription.WebhookID, delivery.LastError)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(DispatchDeviceChanges)
+}
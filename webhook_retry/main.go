@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// RetryDeliveries is the lambda function handler
+// it runs on a schedule and attempts every pending delivery that is due
+func RetryDeliveries(ctx context.Context, evt events.CloudWatchEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	client := webhook.NewClient()
+
+	deliveries, err := webhook.DueDeliveries(dynamoService, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for i, delivery := range deliveries {
+		// Stop early rather than get cut off by the lambda timeout,
+		// whatever is left is picked up by the next run
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < 15*time.Second {
+			log.Printf("Stopping with %d deliveries left for the next run\n", len(deliveries)-i)
+			break
+		}
+
+		subscription, err := webhook.GetSubscription(dynamoService, delivery.Owner, delivery.WebhookID)
+		if err == webhook.ErrNotFound {
+			// The webhook was deleted since, the delivery can never succeed
+			delivery.LastError = "webhook was deleted"
+			delivery.UpdatedAt = time.Now().UTC().Format(webhook.TimestampFormat)
+			_, err = webhook.DeadLetter(dynamoService, delivery)
+			if err != nil {
+				log.Println("Error dead lettering delivery:", err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up webhook %s: %v", delivery.WebhookID, err)
+		}
+
+		delivery, err = webhook.Attempt(ctx, dynamoService, client, subscription, delivery, true)
+		if err != nil {
+			log.Println("Error recording delivery attempt:", err)
+			continue
+		}
+
+		switch delivery.Status {
+		case webhook.StatusDelivered:
+			log.Printf("Delivery %s to webhook %s succeeded on attempt %d\n", delivery.DeliveryID, delivery.WebhookID, delivery.Attempts)
+		case webhook.StatusDead:
+			log.Printf("Delivery %s to webhook %s dead lettered after %d attempts\n", delivery.DeliveryID, delivery.WebhookID, delivery.Attempts)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(RetryDeliveries)
+}
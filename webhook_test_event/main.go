@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/webhook"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// Response defines the response structure to this test event request
+type Response struct {
+	Message  string            `json:"Response"`
+	Error    string            `json:"Error"`
+	Delivery *webhook.Delivery `json:"Delivery,omitempty"`
+}
+
+// SendTestEvent is the lambda function handler
+// it delivers a webhook.test event right away and reports the outcome
+func SendTestEvent(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	webhookID := req.PathParameters["id"]
+	if webhookID == "" {
+		resp := Response{
+			Message: "id missing from request path",
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer["claims"] == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+	typedAuthorizer, ok := authorizer["claims"].(map[string]interface{})
+	if ok != true {
+		resp := Response{
+			Message: "Error getting authorization information from cognito token",
+			Error:   "Error unmarshaling request context",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 500,
+		}, nil
+	}
+
+	subFromToken, _ := typedAuthorizer["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	subscription, err := webhook.GetSubscription(dynamoService, subFromToken, webhookID)
+	if err == webhook.ErrNotFound {
+		resp := Response{
+			Message: fmt.Sprintf("Webhook not found: %s", webhookID),
+			Error:   "Webhook lookup error",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 404}, nil
+	}
+	if err != nil {
+		log.Println("Error looking up webhook (dynamo)", err)
+		resp := Response{
+			Message: "Error looking up webhook",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	payload, err := webhook.NewEvent(webhook.NewID(), webhook.EventTest, webhook.Device{Owner: subscription.Owner}, nil)
+	if err != nil {
+		log.Println("Error marshalling test event:", err)
+		panic(err)
+	}
+
+	// Test events are attempted once and never retried
+	delivery := webhook.NewDelivery(subscription, webhook.EventTest, payload)
+	delivery, err = webhook.Attempt(ctx, dynamoService, webhook.NewClient(), subscription, delivery, false)
+	if err != nil {
+		log.Println("Error recording test delivery (dynamo)", err)
+	}
+
+	if delivery.Status != webhook.StatusDelivered {
+		resp := Response{
+			Message:  fmt.Sprintf("Test event to webhook %s failed", webhookID),
+			Error:    "Delivery failed",
+			Delivery: &delivery,
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 502}, nil
+	}
+
+	resp := Response{
+		Message:  fmt.Sprintf("Successfully delivered test event to webhook %s", webhookID),
+		Delivery: &delivery,
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(rbac.Require(rbac.WebhookWrite, SendTestEvent))
+}
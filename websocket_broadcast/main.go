@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/devicestream"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/live"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+)
+
+// BroadcastDeviceChanges is the lambda function handler
+// it consumes the devices table stream and pushes every created or
+// updated device to the open WebSocket connections of its owner
+func BroadcastDeviceChanges(ctx context.Context, evt events.DynamoDBEvent) error {
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+	poster := live.NewPoster(sess, os.Getenv("WEBSOCKET_ENDPOINT"))
+
+	for _, record := range evt.Records {
+		var messageType string
+		switch record.EventName {
+		case "INSERT":
+			messageType = live.MessageDeviceCreated
+		case "MODIFY":
+			messageType = live.MessageDeviceUpdated
+		default:
+			continue
+		}
+
+		item := devicestream.Item(record.Change.NewImage)
+
+		message := live.Message{
+			Type: messageType,
+			Device: live.Device{
+				MAC:    devicestream.String(item, "MAC"),
+				Name:   devicestream.String(item, "Name"),
+				Owner:  devicestream.String(item, "Owner"),
+				Status: devicestream.String(item, "Status"),
+			},
+		}
+
+		failed, err := live.Broadcast(ctx, dynamoService, poster, message.Device.Owner, message)
+		if err != nil {
+			// Returning the error makes lambda retry the batch
+			return fmt.Errorf("error broadcasting %s: %v", message.Device.MAC, err)
+		}
+		// Live updates are best effort, a client that missed one
+		// catches up when it reloads the device list
+		for connectionID, err := range failed {
+			log.Printf("Error pushing %s to connection %s: %v\n", message.Device.MAC, connectionID, err)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("WEBSOCKET_ENDPOINT") == "" {
+		log.Fatal("WEBSOCKET_ENDPOINT not set")
+	}
+
+	lambda.Start(BroadcastDeviceChanges)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/auth"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/idtoken"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/live"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/rbac"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+// Response defines the response structure to this connection request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// verifier is kept across invocations so the user pool keys
+// are only fetched once per container
+var verifier *idtoken.Verifier
+
+// Connect is the lambda function handler of the $connect route
+// Browsers cannot set headers on WebSocket requests, so the token is
+// taken from the token query parameter when the header is missing
+func Connect(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	token := req.Headers["X-HERMES-CLOUD-TOKEN"]
+	if token == "" {
+		token = req.QueryStringParameters["token"]
+	}
+	if token == "" {
+		resp := Response{
+			Message: "No authorization token provided",
+			Error:   "Missing token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+
+	claims, err := verifier.Verify(ctx, token)
+	if err != nil {
+		if err != idtoken.ErrInvalidToken {
+			log.Println("Error verifying token", err)
+		}
+		resp := Response{
+			Message: "Invalid authorization token provided",
+			Error:   "Invalid token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 401,
+		}, nil
+	}
+
+	// Live updates carry the state of devices
+	if !rbac.Allowed(claims, rbac.DeviceRead) {
+		return rbac.Forbidden(rbac.DeviceRead), nil
+	}
+
+	subFromToken, _ := claims["sub"].(string)
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	signedOut, err := auth.SignedOut(dynamoService, token)
+	if err != nil {
+		log.Println("Error checking sign out (dynamo):", err)
+		resp := Response{
+			Message: "Error opening connection",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+	if signedOut {
+		resp := Response{
+			Message: "Invalid authorization token provided",
+			Error:   "Invalid token",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 401}, nil
+	}
+
+	connection := live.Connection{
+		ConnectionID: req.RequestContext.ConnectionID,
+		Owner:        subFromToken,
+		ConnectedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	err = live.PutConnection(dynamoService, connection)
+	if err != nil {
+		log.Println("Error storing connection (dynamo)", err)
+		resp := Response{
+			Message: "Error opening connection",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	if os.Getenv("COGNITO_USER_POOL_ID") == "" {
+		log.Fatal("COGNITO_USER_POOL_ID not set")
+	}
+
+	if os.Getenv("COGNITO_APP_CLIENT_ID") == "" {
+		log.Fatal("COGNITO_APP_CLIENT_ID not set")
+	}
+
+	verifier = &idtoken.Verifier{
+		Region:     os.Getenv("AWS_REGION"),
+		UserPoolID: os.Getenv("COGNITO_USER_POOL_ID"),
+		ClientID:   os.Getenv("COGNITO_APP_CLIENT_ID"),
+	}
+
+	lambda.Start(Connect)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Bjorn248/Hermes-Cloud-Backend/live"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"regexp"
+)
+
+// MaxDevices is the number of devices a connection can limit itself to
+const MaxDevices = 100
+
+// SubscribeEvent defines the message structure of this subscribe request
+type SubscribeEvent struct {
+	Action string `json:"action"`
+	// Devices limits the pushed devices to these MACs, every
+	// device of the user is pushed when it is empty
+	Devices []string `json:"devices"`
+}
+
+// Response defines the response structure to this subscribe request
+type Response struct {
+	Message string `json:"Response"`
+	Error   string `json:"Error"`
+}
+
+// Subscribe is the lambda function handler of the subscribe route
+// Only devices of the user that opened the connection are ever pushed,
+// so the MACs are not checked against the devices table
+func Subscribe(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var evt SubscribeEvent
+	err := json.Unmarshal([]byte(req.Body), &evt)
+	if err != nil {
+		resp := Response{
+			Message: "Error unmarshalling message",
+			Error:   err.Error(),
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	if len(evt.Devices) > MaxDevices {
+		resp := Response{
+			Message: fmt.Sprintf("A connection can subscribe to at most %d devices", MaxDevices),
+			Error:   "Invalid Request",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{
+			Body:       string(marshalledResponse),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// Validate the MACs
+	devices := []string{}
+	seenDevices := make(map[string]bool)
+	for _, mac := range evt.Devices {
+		validMAC, _ := regexp.MatchString("^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$", mac)
+		if validMAC == false {
+			resp := Response{
+				Message: fmt.Sprintf("Invalid MAC Address Provided: %s", mac),
+				Error:   "Invalid Request",
+			}
+			marshalledResponse, err := json.Marshal(resp)
+			if err != nil {
+				log.Println("Error marshalling response:", resp)
+				panic(err)
+			}
+			return events.APIGatewayProxyResponse{
+				Body:       string(marshalledResponse),
+				StatusCode: 400,
+			}, nil
+		}
+		if !seenDevices[mac] {
+			seenDevices[mac] = true
+			devices = append(devices, mac)
+		}
+	}
+
+	sess := session.Must(session.NewSession())
+
+	dynamoService := dynamodb.New(sess)
+
+	err = live.Subscribe(dynamoService, req.RequestContext.ConnectionID, devices)
+	if err != nil {
+		log.Println("Error subscribing connection (dynamo)", err)
+		resp := Response{
+			Message: "Error subscribing to device updates",
+			Error:   "Something went wrong",
+		}
+		marshalledResponse, err := json.Marshal(resp)
+		if err != nil {
+			log.Println("Error marshalling response:", resp)
+			panic(err)
+		}
+		return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 500}, nil
+	}
+
+	resp := Response{
+		Message: "Successfully subscribed to device updates",
+	}
+	marshalledResponse, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Error marshalling response:", resp)
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{Body: string(marshalledResponse), StatusCode: 200}, nil
+}
+
+func main() {
+	if os.Getenv("AWS_PROFILE") != "" {
+		log.Printf("Using AWS Profile: %s\n", os.Getenv("AWS_PROFILE"))
+	} else {
+		log.Println("Using AWS Profile: default")
+	}
+
+	if os.Getenv("AWS_REGION") == "" {
+		log.Fatal("AWS_REGION not set")
+	}
+
+	lambda.Start(Subscribe)
+}